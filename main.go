@@ -3,10 +3,22 @@
 package main
 
 import (
+	"context"
+	"os"
+	"time"
+
 	"github.com/golang/glog"
 	flag "github.com/spf13/pflag"
+	"github.com/yahoo/privilege-cmd-controller/pkg/controller"
+	versioned "github.com/yahoo/privilege-cmd-controller/pkg/generated/clientset/versioned"
 	"github.com/yahoo/privilege-cmd-controller/pkg/k8sutil"
+	"github.com/yahoo/privilege-cmd-controller/pkg/podpatcher"
 	"github.com/yahoo/privilege-cmd-controller/pkg/privexecutor"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 // main initiates the Privilege Command Controller across all namespaces
@@ -19,6 +31,20 @@ func main() {
 	_ = flag.StringP("namespace", "n", "kube-pcc", "Namespace for privileged pod to be scheduled")
 	_ = flag.StringP("serviceaccount", "s", "kube-priv-pod", "Service account for privileged pod to be scheduled")
 	_ = flag.IntP("privPodTimeout", "t", 300, "Timeout for checking running status of the privilege pod in seconds")
+	_ = flag.String("agent-mode", "ondemand", "Privileged pod lifecycle strategy: 'ondemand' creates and deletes a pod per request, 'daemonset' reuses a long-lived per-node agent pod")
+	_ = flag.Int("agent-lease-max-age", 3600, "Maximum seconds an agent pod's lease may go unrefreshed before it is garbage collected; only used in --agent-mode=daemonset")
+	_ = flag.String("container-runtime", "auto", "Container runtime used both to pick which socket ('docker', 'containerd', or 'crio') the privilege pod mounts and to resolve a container's host PID; 'auto' detects the running node's runtime instead of assuming Docker")
+	apiMode := flag.String("api-mode", "annotations", "API driving privileged command requests: 'annotations' watches pod annotations (default), 'crd' reconciles PrivilegeCommand custom resources")
+	_ = flag.String("policy-file", "", "Path to a YAML allow/deny policy file gating which actions may be executed; if unset, all actions are allowed")
+	_ = flag.String("policy-rego-file", "", "Path to a Rego module evaluating data.pcc.allow against each request, in place of --policy-file; takes precedence over --policy-file when both are set")
+	streamAddr := flag.String("stream-addr", "", "Address to serve live privileged command output on, e.g. ':9090'; if unset, the stream server is disabled")
+	_ = flag.Int("max-retries", 5, "Maximum number of times a failed request is retried with backoff before it is transitioned to the error status")
+	_ = flag.Int("retry-max-elapsed", 300, "Maximum backoff, in seconds, between retries of a failed request")
+	_ = flag.String("auditLogPath", "", "Path to append a JSON audit line per privileged command request; if unset, audit lines are written to stdout")
+	_ = flag.String("patch-strategy", podpatcher.PatchStrategyStrategicMerge, "How pod annotation updates are patched onto the apiserver: 'strategic-merge' (default), 'json-patch', 'json-merge-patch', or 'server-side-apply'")
+	leaderElect := flag.Bool("leader-elect", true, "Enable leader election so only one of several controller replicas processes events at a time")
+	leaderElectionNamespace := flag.String("leader-election-namespace", "kube-pcc", "Namespace of the Lease object used for leader election")
+	leaderElectionName := flag.String("leader-election-name", "privilege-cmd-controller", "Name of the Lease object used for leader election")
 	_ = flag.Set("logtostderr", "true")
 	flag.Parse()
 
@@ -33,9 +59,102 @@ func main() {
 		return
 	}
 
-	// Initiate Privilege Command Controller
-	stop := make(chan struct{})
-	privilegeCmdController := privexecutor.NewPrivilegeCmdController(client, restConfig)
-	defer close(stop)
-	privilegeCmdController.Controller.Run(stop)
+	if *streamAddr != "" {
+		go func() {
+			if err := privexecutor.ListenAndServeStream(*streamAddr); err != nil {
+				glog.Errorf("Stream server error: %v", err)
+			}
+		}()
+	}
+
+	if *apiMode == privexecutor.APIModeCRD {
+		runCRDController(restConfig, client, *leaderElect, *leaderElectionNamespace, *leaderElectionName)
+		return
+	}
+
+	if !*leaderElect {
+		runController(context.Background(), restConfig, client)
+		return
+	}
+
+	runWithLeaderElection(client, *leaderElectionNamespace, *leaderElectionName, func(ctx context.Context) {
+		runController(ctx, restConfig, client)
+	})
+}
+
+// runController starts the annotation-driven Privilege Command Controller; it and its retry
+// worker stop as soon as ctx is done
+func runController(ctx context.Context, restConfig *rest.Config, client kubernetes.Interface) {
+	privilegeCmdController := privexecutor.NewPrivilegeCmdController(ctx, client, restConfig)
+	privilegeCmdController.StartRetryWorker()
+	privilegeCmdController.StartLeaseGarbageCollector()
+	privilegeCmdController.Controller.Run(ctx.Done())
+}
+
+// runCRDController runs the PrivilegeCommand reconciler in place of the annotation-watching informer
+func runCRDController(restConfig *rest.Config, client kubernetes.Interface, leaderElect bool, leaderElectionNamespace string, leaderElectionName string) {
+	privilegeClient, err := versioned.NewForConfig(restConfig)
+	if err != nil {
+		glog.Fatalf("Failed to create PrivilegeCommand client: %v", err)
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		LeaderElection:          leaderElect,
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaderElectionID:        leaderElectionName,
+	})
+	if err != nil {
+		glog.Fatalf("Failed to create controller manager: %v", err)
+	}
+
+	reconciler := controller.NewReconciler(privilegeClient, privexecutor.NewCRDExecutor(client, restConfig))
+	if err := controller.SetupWithManager(mgr, reconciler); err != nil {
+		glog.Fatalf("Failed to set up PrivilegeCommand reconciler: %v", err)
+	}
+
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		glog.Fatalf("Controller manager exited with error: %v", err)
+	}
+}
+
+// runWithLeaderElection runs run only while holding the namespace/name Lease, so at most one of
+// several replicas processes pod annotation events at a time. Losing the lease cancels run's
+// ctx, which stops the informer, the retry worker, and any in-flight privileged pod watch, and
+// the process then exits so a fresh replica can compete for the lease.
+//
+// The controller's ServiceAccount needs RBAC to create/get/update coordination.k8s.io/v1 Leases
+// named leaderElectionName in leaderElectionNamespace.
+func runWithLeaderElection(client kubernetes.Interface, leaderElectionNamespace string, leaderElectionName string, run func(ctx context.Context)) {
+	id, err := os.Hostname()
+	if err != nil {
+		glog.Fatalf("Failed to determine leader election identity: %v", err)
+	}
+
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, leaderElectionNamespace, leaderElectionName,
+		client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: id})
+	if err != nil {
+		glog.Fatalf("Failed to create leader election lock: %v", err)
+	}
+
+	leaderelection.RunOrDie(context.Background(), leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				glog.Infof("%s: acquired leadership on lease %s/%s, starting controller", id, leaderElectionNamespace, leaderElectionName)
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				glog.Fatalf("%s: lost leadership on lease %s/%s, exiting", id, leaderElectionNamespace, leaderElectionName)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					glog.Infof("New leader elected: %s", identity)
+				}
+			},
+		},
+	})
 }
@@ -0,0 +1,166 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+
+// Package agent manages a long-lived per-node privilege agent, deployed as a
+// DaemonSet, so that privileged commands can be executed against an
+// already-running pod on the target node instead of paying pod scheduling
+// and image-pull latency on every request.
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DaemonSetName is the name of the DaemonSet that runs the agent pod on every node
+	DaemonSetName = "priv-cmd-agent"
+
+	// AppLabel is the label selector key identifying an agent pod
+	AppLabel = "app"
+	// AppLabelValue is the label selector value identifying an agent pod
+	AppLabelValue = "priv-cmd-agent"
+
+	// LeaseAnnotation is the annotation on an agent pod tracking the last time it was claimed for a request
+	LeaseAnnotation = "priv-cmd-agent/last-leased"
+	// NsenterProbeBinary is the binary probed on the agent container to determine health
+	NsenterProbeBinary = "nsenter"
+)
+
+// Pool manages the per-node agent DaemonSet and hands out agent pods to requests
+type Pool struct {
+	client    kubernetes.Interface
+	namespace string
+	image     string
+}
+
+// NewPool returns a Pool that reconciles the agent DaemonSet in namespace using image
+func NewPool(client kubernetes.Interface, namespace string, image string) *Pool {
+	return &Pool{
+		client:    client,
+		namespace: namespace,
+		image:     image,
+	}
+}
+
+// Reconcile creates the agent DaemonSet if it does not already exist, or updates its image if it has drifted
+func (p *Pool) Reconcile() error {
+	desired := daemonSetSpec(p.namespace, p.image)
+
+	existing, err := p.client.AppsV1().DaemonSets(p.namespace).Get(DaemonSetName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		glog.Infof("Creating agent DaemonSet %s in namespace %s", DaemonSetName, p.namespace)
+		_, err = p.client.AppsV1().DaemonSets(p.namespace).Create(desired)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get agent DaemonSet %s: %s", DaemonSetName, err)
+	}
+
+	if existing.Spec.Template.Spec.Containers[0].Image != p.image {
+		glog.Infof("Updating agent DaemonSet %s image to %s", DaemonSetName, p.image)
+		existing.Spec.Template.Spec.Containers[0].Image = p.image
+		_, err = p.client.AppsV1().DaemonSets(p.namespace).Update(existing)
+		return err
+	}
+	return nil
+}
+
+// AgentForNode locates the agent pod already running on nodeName and refreshes its lease annotation.
+// A plain DaemonSet has no way to stamp a per-node label onto its pod template, so nodeName is
+// matched via a field selector on spec.nodeName rather than a label selector.
+func (p *Pool) AgentForNode(nodeName string) (*v1.Pod, error) {
+	labelSelector := fmt.Sprintf("%s=%s", AppLabel, AppLabelValue)
+	fieldSelector := fmt.Sprintf("spec.nodeName=%s", nodeName)
+	pods, err := p.client.CoreV1().Pods(p.namespace).List(metav1.ListOptions{LabelSelector: labelSelector, FieldSelector: fieldSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agent pods on node %s: %s", nodeName, err)
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("no agent pod found on node %s with label selector %s", nodeName, labelSelector)
+	}
+
+	agentPod := &pods.Items[0]
+	if err := p.refreshLease(agentPod); err != nil {
+		return nil, fmt.Errorf("failed to refresh lease on agent pod %s: %s", agentPod.Name, err)
+	}
+	return agentPod, nil
+}
+
+// refreshLease stamps the agent pod with the current time so GarbageCollectLeases can detect abandoned leases
+func (p *Pool) refreshLease(pod *v1.Pod) error {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[LeaseAnnotation] = time.Now().Format(time.RFC3339)
+	_, err := p.client.CoreV1().Pods(pod.Namespace).Update(pod)
+	return err
+}
+
+// GarbageCollectLeases deletes lease annotations on agent pods that have not been leased within maxAge
+func (p *Pool) GarbageCollectLeases(maxAge time.Duration) error {
+	selector := fmt.Sprintf("%s=%s", AppLabel, AppLabelValue)
+	pods, err := p.client.CoreV1().Pods(p.namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list agent pods for lease garbage collection: %s", err)
+	}
+
+	for i := range pods.Items {
+		agentPod := &pods.Items[i]
+		leasedAt, ok := agentPod.Annotations[LeaseAnnotation]
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, leasedAt)
+		if err != nil || time.Since(t) <= maxAge {
+			continue
+		}
+		glog.Infof("Garbage collecting stale lease on agent pod %s", agentPod.Name)
+		delete(agentPod.Annotations, LeaseAnnotation)
+		if _, err := p.client.CoreV1().Pods(agentPod.Namespace).Update(agentPod); err != nil {
+			glog.Errorf("Failed to garbage collect lease on agent pod %s: %s", agentPod.Name, err)
+		}
+	}
+	return nil
+}
+
+// daemonSetSpec is the specification of the agent DaemonSet deployed to every node
+func daemonSetSpec(namespace, image string) *appsv1.DaemonSet {
+	labels := map[string]string{AppLabel: AppLabelValue}
+	privileged := true
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DaemonSetName,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					HostPID: true,
+					Containers: []v1.Container{{
+						Name:            AppLabelValue,
+						Image:           image,
+						ImagePullPolicy: v1.PullIfNotPresent,
+						SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+						ReadinessProbe: &v1.Probe{
+							Handler: v1.Handler{
+								Exec: &v1.ExecAction{Command: []string{"which", NsenterProbeBinary}},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
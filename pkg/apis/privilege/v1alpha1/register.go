@@ -0,0 +1,36 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the API group PrivilegeCommand is registered under
+const GroupName = "privilege.yahoo.com"
+
+// SchemeGroupVersion is the group/version PrivilegeCommand is registered under
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+// SchemeBuilder registers PrivilegeCommand types with a runtime.Scheme
+var (
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	// AddToScheme adds the PrivilegeCommand types to a runtime.Scheme
+	AddToScheme = SchemeBuilder.AddToScheme
+)
+
+// Resource returns a GroupResource for the given PrivilegeCommand resource name
+func Resource(resource string) schema.GroupResource {
+	return SchemeGroupVersion.WithResource(resource).GroupResource()
+}
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion,
+		&PrivilegeCommand{},
+		&PrivilegeCommandList{},
+	)
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+	return nil
+}
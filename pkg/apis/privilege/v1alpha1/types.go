@@ -0,0 +1,127 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+
+// Package v1alpha1 contains the PrivilegeCommand custom resource, the typed replacement for the
+// three pod annotations (AnnotationExecuteContainer, AnnotationExecuteAction, AnnotationExecuteStatus)
+// that previously doubled as both the request and the response for a privileged command.
+//
+// An earlier revision of this effort proposed the type under a separate group as
+// PrivilegedCommandRequest; it was consolidated onto this PrivilegeCommand type instead so
+// pkg/controller, the generated clientset/informers, and the annotation compatibility shim in
+// pkg/privexecutor all reconcile one CRD rather than two with overlapping purposes.
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phase is the lifecycle state of a PrivilegeCommand
+type Phase string
+
+const (
+	// PhasePending means the command has been accepted but not yet started
+	PhasePending Phase = "Pending"
+	// PhaseRunning means the privileged pod is executing the command
+	PhaseRunning Phase = "Running"
+	// PhaseSucceeded means the command completed with exit code 0
+	PhaseSucceeded Phase = "Succeeded"
+	// PhaseFailed means the command could not be run or exited non-zero
+	PhaseFailed Phase = "Failed"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PrivilegeCommand is the typed request/response for executing a privileged command against a
+// container, replacing the privileged-command-* pod annotations
+type PrivilegeCommand struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PrivilegeCommandSpec   `json:"spec"`
+	Status PrivilegeCommandStatus `json:"status,omitempty"`
+}
+
+// PrivilegeCommandSpec names the target of a privileged command and the command to run
+type PrivilegeCommandSpec struct {
+	// PodName is the name of the target pod
+	PodName string `json:"podName"`
+	// Container is the name of the target container within PodName
+	Container string `json:"container"`
+	// Command is the argv to execute under nsenter against the target container
+	Command []string `json:"command"`
+	// TimeoutSeconds bounds how long the privileged pod may take to start and run Command before
+	// the command is failed; a privileged pod that is not Running in time fails with reason
+	// PrivPodTimeout. Defaults to CmdArgs.PrivPodTimeout if unset.
+	TimeoutSeconds *int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// ConditionType is a type of condition reported on a PrivilegeCommand's status
+type ConditionType string
+
+// ConditionReady describes whether the privileged pod backing a PrivilegeCommand came up and ran
+// Spec.Command; its Reason distinguishes why not when Status is false
+const ConditionReady ConditionType = "Ready"
+
+const (
+	// ReasonPrivPodTimeout means the privileged pod did not reach Running before TimeoutSeconds elapsed
+	ReasonPrivPodTimeout = "PrivPodTimeout"
+	// ReasonPrivPodImagePullFailed means the privileged pod's image could not be pulled
+	ReasonPrivPodImagePullFailed = "PrivPodImagePullFailed"
+	// ReasonTargetContainerNotFound means Spec.Container does not exist on Spec.PodName
+	ReasonTargetContainerNotFound = "TargetContainerNotFound"
+	// ReasonNodeNotReady means the privileged pod's node went unreachable while it was running
+	ReasonNodeNotReady = "NodeNotReady"
+	// ReasonEvicted means the privileged pod was evicted, e.g. under node resource pressure
+	ReasonEvicted = "Evicted"
+)
+
+// Condition is a single observation of a PrivilegeCommand's status, following the same
+// Type/Status/Reason/Message shape as core/v1's pod conditions
+type Condition struct {
+	// Type is the condition being reported on, e.g. ConditionReady
+	Type ConditionType `json:"type"`
+	// Status is True, False, or Unknown
+	Status v1.ConditionStatus `json:"status"`
+	// Reason is a short machine-readable identifier for the condition's cause, e.g. ReasonPrivPodTimeout
+	Reason string `json:"reason,omitempty"`
+	// Message is a human-readable detail of the condition's cause
+	Message string `json:"message,omitempty"`
+	// LastTransitionTime is when Status last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// PrivilegeCommandStatus carries the outcome of executing a PrivilegeCommand
+type PrivilegeCommandStatus struct {
+	// Phase is the current lifecycle state of the command
+	Phase Phase `json:"phase,omitempty"`
+	// ReqID is the request ID assigned when the command was first processed
+	ReqID string `json:"reqID,omitempty"`
+	// Conditions records why the command is, or is not, Ready
+	Conditions []Condition `json:"conditions,omitempty"`
+	// ObservedGeneration is the Spec generation this status was last computed from
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// PrivPodRef names the privileged pod created on the target node to run Command
+	PrivPodRef *v1.LocalObjectReference `json:"privPodRef,omitempty"`
+	// StartTime is when the privileged pod began executing Spec.Command
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is when Spec.Command finished, successfully or not
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// ExitCode is the exit code of Spec.Command, valid once Phase is Succeeded or Failed
+	ExitCode *int32 `json:"exitCode,omitempty"`
+	// Output is the combined stdout/stderr of Spec.Command, truncated to pkg/controller's
+	// maxStatusOutputBytes; there is no overflow path to a Secret or other object for the
+	// untruncated remainder, so a caller that needs the full output of a chatty command must
+	// attach a live stream via --stream-addr while the command is still running.
+	Output string `json:"output,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PrivilegeCommandList is a list of PrivilegeCommand resources
+type PrivilegeCommandList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []PrivilegeCommand `json:"items"`
+}
@@ -4,14 +4,25 @@ package constants
 
 const (
 	// Common annotation names and statuses
-	AnnotationExecuteStatus    = "privileged-command-status"    // annotation provided by kubectl plugin for checking status of pod
-	AnnotationExecuteContainer = "privileged-command-container" // annotation provided by kubectl plugin for the container name
-	AnnotationExecuteAction    = "privileged-command-action"    // annotation provided by kubectl plugin for the action to execute
-	StatusActive               = "active"                       // status for privileged-command-status is active
-	StatusInProgress           = "in-progress"                  // status for privileged-command-status is in progress
-	StatusDone                 = "done"                         // status for privileged-command-status is done
-	StatusError                = "error"                        // status for privileged-command-status is error
+	//
+	// Deprecated: these annotations are the compatibility path for the kubectl plugin and are
+	// superseded by the typed PrivilegeCommand CRD (see pkg/apis/privilege/v1alpha1 and
+	// pkg/controller), selected with --api-mode=crd. They remain the default (--api-mode=annotations)
+	// for one release so existing plugin users are unaffected.
+	AnnotationExecuteStatus    = "privileged-command-status"                   // annotation provided by kubectl plugin for checking status of pod
+	AnnotationExecuteContainer = "privileged-command-container"                // annotation provided by kubectl plugin for the container name
+	AnnotationExecuteAction    = "privileged-command-action"                   // annotation provided by kubectl plugin for the action to execute
+	AnnotationExecuteRequester = "privileged-command-requester"                // required annotation provided by kubectl plugin identifying the user making the request
+	AnnotationLastApplied      = "privileged-command-last-applied-annotations" // last-applied set of controller-owned annotations, used to three-way merge subsequent annotation patches
+	StatusActive               = "active"                                      // status for privileged-command-status is active
+	StatusInProgress           = "in-progress"                                 // status for privileged-command-status is in progress
+	StatusDone                 = "done"                                        // status for privileged-command-status is done
+	StatusError                = "error"                                       // status for privileged-command-status is error
 
 	// Privilege pod specifications
 	PrivilegeContainer = "priv-pod" // container of the privilege pod
+
+	// StreamRequesterHeader is the header a client hitting --stream-addr must set to the reqID's
+	// recorded requester identity; see pkg/privexecutor/stream_server.go
+	StreamRequesterHeader = "X-Privileged-Command-Requester"
 )
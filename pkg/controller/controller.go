@@ -0,0 +1,163 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+
+// Package controller reconciles PrivilegeCommand custom resources, superseding the
+// annotation-driven handleUpdate path in pkg/privexecutor. It is wired in behind the
+// --api-mode=crd flag so existing annotation-based deployments are unaffected for one release.
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+	privilegev1alpha1 "github.com/yahoo/privilege-cmd-controller/pkg/apis/privilege/v1alpha1"
+	versioned "github.com/yahoo/privilege-cmd-controller/pkg/generated/clientset/versioned"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// maxStatusOutputBytes caps Status.Output so a chatty command's output can't inflate a
+// PrivilegeCommand past the apiserver/etcd object size limits. Output beyond this cap is dropped,
+// not spilled anywhere else: operators who need the full output should attach a live stream via
+// --stream-addr (see pkg/privexecutor/stream_server.go) while the command is still running.
+const maxStatusOutputBytes = 32 * 1024
+
+// truncateOutput caps output at maxStatusOutputBytes, appending a marker noting how much was cut
+func truncateOutput(output string) string {
+	if len(output) <= maxStatusOutputBytes {
+		return output
+	}
+	return fmt.Sprintf("%s\n...[truncated %d bytes]", output[:maxStatusOutputBytes], len(output)-maxStatusOutputBytes)
+}
+
+// ExecResult is the outcome of running a PrivilegeCommand's Spec.Command
+type ExecResult struct {
+	// Output is the combined stdout/stderr of Command
+	Output string
+	// ExitCode is Command's exit code, valid only when err is nil
+	ExitCode int32
+	// PrivPodName is the privileged pod created to run Command, recorded in Status.PrivPodRef
+	PrivPodName string
+	// Reason is set when err is non-nil and matches one of the v1alpha1.Reason* constants, or ""
+	// if the failure does not fall into one of those known categories
+	Reason string
+}
+
+// Executor runs Spec.Command against Spec.PodName/Spec.Container, bounded by timeoutSeconds if set
+type Executor interface {
+	Execute(reqID string, podName string, container string, command []string, timeoutSeconds *int32) (ExecResult, error)
+}
+
+// Reconciler reconciles PrivilegeCommand objects, moving them through Pending, Running, and
+// a terminal Succeeded or Failed phase
+type Reconciler struct {
+	client   versioned.Interface
+	executor Executor
+}
+
+// NewReconciler returns a Reconciler that executes commands via executor and records status through client
+func NewReconciler(client versioned.Interface, executor Executor) *Reconciler {
+	return &Reconciler{client: client, executor: executor}
+}
+
+// SetupWithManager registers the Reconciler to watch PrivilegeCommand objects on mgr
+func SetupWithManager(mgr manager.Manager, r *Reconciler) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&privilegev1alpha1.PrivilegeCommand{}).
+		Complete(r)
+}
+
+// Reconcile moves a single PrivilegeCommand through its lifecycle. It is idempotent: reconciling
+// a command already in a terminal phase is a no-op.
+func (r *Reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	cmd, err := r.client.PrivilegeV1alpha1().PrivilegeCommands(req.Namespace).Get(req.Name, metav1.GetOptions{})
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get PrivilegeCommand %s/%s: %s", req.Namespace, req.Name, err)
+	}
+
+	switch cmd.Status.Phase {
+	case privilegev1alpha1.PhaseSucceeded, privilegev1alpha1.PhaseFailed:
+		return reconcile.Result{}, nil
+	case privilegev1alpha1.PhaseRunning:
+		// a previous reconcile already kicked off execution; nothing further to drive here until it completes
+		return reconcile.Result{}, nil
+	}
+
+	glog.Infof("[%s/%s] Running command %v on container %s of pod %s", req.Namespace, req.Name, cmd.Spec.Command, cmd.Spec.Container, cmd.Spec.PodName)
+	cmd.Status.ReqID = string(cmd.UID)
+	if err := r.transitionTo(cmd, privilegev1alpha1.PhaseRunning, "", nil); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	result, execErr := r.executor.Execute(string(cmd.UID), cmd.Spec.PodName, cmd.Spec.Container, cmd.Spec.Command, cmd.Spec.TimeoutSeconds)
+	cmd.Status.Output = truncateOutput(result.Output)
+	cmd.Status.ExitCode = &result.ExitCode
+	if result.PrivPodName != "" {
+		cmd.Status.PrivPodRef = &v1.LocalObjectReference{Name: result.PrivPodName}
+	}
+
+	phase := privilegev1alpha1.PhaseSucceeded
+	if execErr != nil || result.ExitCode != 0 {
+		phase = privilegev1alpha1.PhaseFailed
+	}
+	if err := r.transitionTo(cmd, phase, result.Reason, execErr); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// transitionTo updates cmd.Status.Phase, records a Ready condition carrying reason and execErr's
+// message if any when phase is terminal, and persists the result via UpdateStatus
+func (r *Reconciler) transitionTo(cmd *privilegev1alpha1.PrivilegeCommand, phase privilegev1alpha1.Phase, reason string, execErr error) error {
+	if execErr != nil {
+		glog.Errorf("[%s/%s] Transitioning to %s after error: %s", cmd.Namespace, cmd.Name, phase, execErr)
+	}
+	cmd.Status.Phase = phase
+	cmd.Status.ObservedGeneration = cmd.Generation
+	now := metav1.Now()
+	if phase == privilegev1alpha1.PhaseRunning {
+		cmd.Status.StartTime = &now
+	}
+	if phase == privilegev1alpha1.PhaseSucceeded || phase == privilegev1alpha1.PhaseFailed {
+		cmd.Status.CompletionTime = &now
+		cmd.Status.Conditions = []privilegev1alpha1.Condition{readyCondition(phase, reason, execErr, now)}
+	}
+
+	updated, updateErr := r.client.PrivilegeV1alpha1().PrivilegeCommands(cmd.Namespace).UpdateStatus(cmd)
+	if updateErr != nil {
+		return fmt.Errorf("failed to update PrivilegeCommand %s/%s status to %s: %s", cmd.Namespace, cmd.Name, phase, updateErr)
+	}
+	// Reassign so a later transitionTo call on the same cmd (Running -> terminal) carries the
+	// resourceVersion UpdateStatus just returned, not the one it was called with.
+	*cmd = *updated
+	return nil
+}
+
+// readyCondition reports whether cmd's command ran to completion, attributing failure to reason
+// (one of privilegev1alpha1's Reason* constants) when the executor identified a known cause
+func readyCondition(phase privilegev1alpha1.Phase, reason string, execErr error, transitionTime metav1.Time) privilegev1alpha1.Condition {
+	if phase == privilegev1alpha1.PhaseSucceeded {
+		return privilegev1alpha1.Condition{
+			Type:               privilegev1alpha1.ConditionReady,
+			Status:             v1.ConditionTrue,
+			LastTransitionTime: transitionTime,
+		}
+	}
+
+	message := ""
+	if execErr != nil {
+		message = execErr.Error()
+	}
+	return privilegev1alpha1.Condition{
+		Type:               privilegev1alpha1.ConditionReady,
+		Status:             v1.ConditionFalse,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: transitionTime,
+	}
+}
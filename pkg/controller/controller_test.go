@@ -0,0 +1,109 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	privilegev1alpha1 "github.com/yahoo/privilege-cmd-controller/pkg/apis/privilege/v1alpha1"
+	fake "github.com/yahoo/privilege-cmd-controller/pkg/generated/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type stubExecutor struct {
+	result ExecResult
+	err    error
+}
+
+func (s *stubExecutor) Execute(reqID string, podName string, container string, command []string, timeoutSeconds *int32) (ExecResult, error) {
+	return s.result, s.err
+}
+
+func TestReconcile_PendingToSucceeded(t *testing.T) {
+	cmd := &privilegev1alpha1.PrivilegeCommand{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "req-1"},
+		Spec:       privilegev1alpha1.PrivilegeCommandSpec{PodName: "target", Container: "app", Command: []string{"id"}},
+	}
+	client := fake.NewSimpleClientset(cmd)
+	executor := &stubExecutor{result: ExecResult{Output: "uid=0(root)\n", ExitCode: 0, PrivPodName: "priv-xyz"}}
+	r := NewReconciler(client, executor)
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "req-1"}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile (Pending->Succeeded): %v", err)
+	}
+
+	got, err := client.PrivilegeV1alpha1().PrivilegeCommands("default").Get("req-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get after first reconcile: %v", err)
+	}
+	if got.Status.Phase != privilegev1alpha1.PhaseSucceeded {
+		t.Fatalf("phase = %s, want Succeeded", got.Status.Phase)
+	}
+
+	// transitionTo is called twice per Reconcile (Running, then terminal) against the same cmd
+	// object, so a second call sending a stale resourceVersion would already have failed the
+	// Reconcile above with a 409 Conflict; drive one more terminal call directly against the
+	// stored object to check it carries the resourceVersion the last UpdateStatus returned.
+	result := ExecResult{Output: "uid=0(root)\n", ExitCode: 0, PrivPodName: "priv-xyz"}
+	got.Status.Output = result.Output
+	exitCode := result.ExitCode
+	got.Status.ExitCode = &exitCode
+	if err := r.transitionTo(got, privilegev1alpha1.PhaseSucceeded, "", nil); err != nil {
+		t.Fatalf("transitionTo (Running->Succeeded): %v", err)
+	}
+
+	final, err := client.PrivilegeV1alpha1().PrivilegeCommands("default").Get("req-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get after terminal transition: %v", err)
+	}
+	if final.Status.Phase != privilegev1alpha1.PhaseSucceeded {
+		t.Fatalf("phase = %s, want Succeeded", final.Status.Phase)
+	}
+	if final.Status.Output != "uid=0(root)\n" {
+		t.Fatalf("Output = %q, want preserved output", final.Status.Output)
+	}
+	if final.Status.ExitCode == nil || *final.Status.ExitCode != 0 {
+		t.Fatalf("ExitCode = %v, want 0", final.Status.ExitCode)
+	}
+	if final.Status.CompletionTime == nil {
+		t.Fatal("CompletionTime not set on terminal transition")
+	}
+}
+
+// TestTruncateOutput tests that output at or under the cap passes through unchanged, and
+// output over it is cut to the cap with a marker noting the dropped byte count
+func TestTruncateOutput(t *testing.T) {
+	short := "hello"
+	if got := truncateOutput(short); got != short {
+		t.Errorf("truncateOutput(%d bytes) = %q, want unchanged", len(short), got)
+	}
+
+	long := strings.Repeat("a", maxStatusOutputBytes+100)
+	got := truncateOutput(long)
+	if !strings.HasPrefix(got, strings.Repeat("a", maxStatusOutputBytes)) {
+		t.Errorf("truncateOutput did not preserve the first %d bytes", maxStatusOutputBytes)
+	}
+	if !strings.Contains(got, "truncated 100 bytes") {
+		t.Errorf("truncateOutput(%d bytes) = %q, want a marker noting 100 truncated bytes", len(long), got)
+	}
+}
+
+func TestReconcile_TerminalIsNoOp(t *testing.T) {
+	cmd := &privilegev1alpha1.PrivilegeCommand{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "req-2"},
+		Status:     privilegev1alpha1.PrivilegeCommandStatus{Phase: privilegev1alpha1.PhaseSucceeded},
+	}
+	client := fake.NewSimpleClientset(cmd)
+	r := NewReconciler(client, &stubExecutor{})
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "req-2"}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Reconcile on terminal phase: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+
+// Package fake provides an in-memory versioned.Interface for unit tests, standing in for the
+// REST-backed Clientset without requiring an apiserver. It is hand-maintained rather than
+// client-gen output because the real clientset predates fake-client codegen in this repo.
+package fake
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	privilegev1alpha1 "github.com/yahoo/privilege-cmd-controller/pkg/apis/privilege/v1alpha1"
+	versioned "github.com/yahoo/privilege-cmd-controller/pkg/generated/clientset/versioned"
+	typedv1alpha1 "github.com/yahoo/privilege-cmd-controller/pkg/generated/clientset/versioned/typed/privilege/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Clientset is an in-memory stand-in for versioned.Clientset that round-trips PrivilegeCommand
+// objects through a map, bumping ResourceVersion on every write so stale-object bugs (reusing a
+// pre-update object across two API calls) surface the same way they would against a real apiserver
+type Clientset struct {
+	mu   sync.Mutex
+	objs map[string]*privilegev1alpha1.PrivilegeCommand
+	rv   int
+}
+
+var _ versioned.Interface = &Clientset{}
+
+// NewSimpleClientset returns a Clientset seeded with objects, as NewSimpleClientset does for the
+// real client-gen fakes
+func NewSimpleClientset(objects ...*privilegev1alpha1.PrivilegeCommand) *Clientset {
+	c := &Clientset{objs: map[string]*privilegev1alpha1.PrivilegeCommand{}}
+	for _, o := range objects {
+		o = o.DeepCopy()
+		c.rv++
+		o.ResourceVersion = strconv.Itoa(c.rv)
+		c.objs[key(o.Namespace, o.Name)] = o
+	}
+	return c
+}
+
+func key(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// PrivilegeV1alpha1 returns the fake typed client for the privilege.yahoo.com/v1alpha1 API group
+func (c *Clientset) PrivilegeV1alpha1() typedv1alpha1.PrivilegeV1alpha1Interface {
+	return &fakePrivilegeV1alpha1{c: c}
+}
+
+type fakePrivilegeV1alpha1 struct {
+	c *Clientset
+}
+
+func (f *fakePrivilegeV1alpha1) PrivilegeCommands(namespace string) typedv1alpha1.PrivilegeCommandInterface {
+	return &fakePrivilegeCommands{c: f.c, ns: namespace}
+}
+
+type fakePrivilegeCommands struct {
+	c  *Clientset
+	ns string
+}
+
+func (f *fakePrivilegeCommands) Get(name string, options metav1.GetOptions) (*privilegev1alpha1.PrivilegeCommand, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	obj, ok := f.c.objs[key(f.ns, name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: privilegev1alpha1.SchemeGroupVersion.Group, Resource: "privilegecommands"}, name)
+	}
+	return obj.DeepCopy(), nil
+}
+
+func (f *fakePrivilegeCommands) List(opts metav1.ListOptions) (*privilegev1alpha1.PrivilegeCommandList, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	list := &privilegev1alpha1.PrivilegeCommandList{}
+	for _, obj := range f.c.objs {
+		if obj.Namespace == f.ns {
+			list.Items = append(list.Items, *obj.DeepCopy())
+		}
+	}
+	return list, nil
+}
+
+func (f *fakePrivilegeCommands) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return watch.NewFake(), nil
+}
+
+func (f *fakePrivilegeCommands) Create(obj *privilegev1alpha1.PrivilegeCommand) (*privilegev1alpha1.PrivilegeCommand, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	k := key(f.ns, obj.Name)
+	if _, exists := f.c.objs[k]; exists {
+		return nil, apierrors.NewAlreadyExists(schema.GroupResource{Group: privilegev1alpha1.SchemeGroupVersion.Group, Resource: "privilegecommands"}, obj.Name)
+	}
+	stored := obj.DeepCopy()
+	stored.Namespace = f.ns
+	f.c.rv++
+	stored.ResourceVersion = strconv.Itoa(f.c.rv)
+	f.c.objs[k] = stored
+	return stored.DeepCopy(), nil
+}
+
+func (f *fakePrivilegeCommands) Update(obj *privilegev1alpha1.PrivilegeCommand) (*privilegev1alpha1.PrivilegeCommand, error) {
+	return f.put(obj, false)
+}
+
+func (f *fakePrivilegeCommands) UpdateStatus(obj *privilegev1alpha1.PrivilegeCommand) (*privilegev1alpha1.PrivilegeCommand, error) {
+	return f.put(obj, true)
+}
+
+func (f *fakePrivilegeCommands) put(obj *privilegev1alpha1.PrivilegeCommand, statusOnly bool) (*privilegev1alpha1.PrivilegeCommand, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	k := key(f.ns, obj.Name)
+	existing, ok := f.c.objs[k]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: privilegev1alpha1.SchemeGroupVersion.Group, Resource: "privilegecommands"}, obj.Name)
+	}
+	if obj.ResourceVersion != "" && obj.ResourceVersion != existing.ResourceVersion {
+		return nil, apierrors.NewConflict(schema.GroupResource{Group: privilegev1alpha1.SchemeGroupVersion.Group, Resource: "privilegecommands"}, obj.Name, fmt.Errorf("resourceVersion %q does not match stored %q", obj.ResourceVersion, existing.ResourceVersion))
+	}
+	stored := obj.DeepCopy()
+	stored.Namespace = f.ns
+	if statusOnly {
+		stored.Spec = existing.Spec
+	}
+	f.c.rv++
+	stored.ResourceVersion = strconv.Itoa(f.c.rv)
+	f.c.objs[k] = stored
+	return stored.DeepCopy(), nil
+}
+
+func (f *fakePrivilegeCommands) Delete(name string, options *metav1.DeleteOptions) error {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+	k := key(f.ns, name)
+	if _, ok := f.c.objs[k]; !ok {
+		return apierrors.NewNotFound(schema.GroupResource{Group: privilegev1alpha1.SchemeGroupVersion.Group, Resource: "privilegecommands"}, name)
+	}
+	delete(f.c.objs, k)
+	return nil
+}
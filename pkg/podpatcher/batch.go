@@ -0,0 +1,158 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package podpatcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BatchAnnotationOp is a single pod's annotation reconciliation within a BatchUpdateAnnotations call
+type BatchAnnotationOp struct {
+	Namespace   string
+	PodName     string
+	AddOrUpdate map[string]string
+	DeleteKeys  []string
+}
+
+// BatchAnnotationResult is the per-pod outcome of a BatchUpdateAnnotations call
+type BatchAnnotationResult struct {
+	Op BatchAnnotationOp
+	// Previous is Op's pod's annotations as they were immediately before this op patched them,
+	// recorded so a rollback can restore them; in dry-run mode it is recorded too, though
+	// nothing is applied and no rollback runs.
+	Previous map[string]string
+	// Patch is the strategic-merge (or configured ClientOptions.PatchStrategy) patch bytes this
+	// op computed; only populated in dry-run mode; an applied op does not retain its wire patch.
+	Patch []byte
+	// Err is the failure, if any, patching Op's pod. A rolled-back op's Err still reflects the
+	// transaction failure that triggered the rollback, not the rollback itself - rollback
+	// failures are logged, not attached to any BatchAnnotationResult.
+	Err error
+}
+
+// BatchAnnotationTransactionOptions configures Client.BatchUpdateAnnotations
+type BatchAnnotationTransactionOptions struct {
+	// Concurrency bounds how many ops run at once; <= 0 is treated as 1
+	Concurrency int
+	// MaxRetries is forwarded to UpdateAnnotations for each op (and for rollback); <= 0 is
+	// treated as a single attempt, no retries
+	MaxRetries int
+	// DryRun computes each op's patch bytes without touching the apiserver, and skips rollback
+	DryRun bool
+}
+
+// BatchUpdateAnnotations runs ops concurrently, bounded by opts.Concurrency, each through
+// UpdateAnnotations. If any op fails, every op that had already succeeded is rolled back with a
+// best-effort compensating patch restoring the annotations recorded in its
+// BatchAnnotationResult.Previous; a rollback failure is logged rather than returned, since by
+// that point the transaction has already failed and there is no further recovery to attempt. It
+// returns one BatchAnnotationResult per op, in the same order as ops, and the first op error
+// encountered (nil if every op succeeded).
+//
+// In opts.DryRun, no apiserver write is made: each result's Patch is the patch that would be
+// sent, useful for previewing what a privileged command rollout would change across a whole
+// workload (e.g. every pod of a StatefulSet) before committing to it.
+func (c *Client) BatchUpdateAnnotations(ctx context.Context, reqID string, ops []BatchAnnotationOp, opts BatchAnnotationTransactionOptions) ([]BatchAnnotationResult, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	// Each op and each rollback retries conflicts per opts.MaxRetries, not c.opts.MaxRetries
+	batchClient := *c
+	batchClient.opts.MaxRetries = opts.MaxRetries
+
+	results := make([]BatchAnnotationResult, len(ops))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, op := range ops {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, op BatchAnnotationOp) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = batchClient.runBatchAnnotationOp(ctx, reqID, op, opts)
+		}(i, op)
+	}
+	wg.Wait()
+
+	var firstErr error
+	var succeeded []BatchAnnotationResult
+	for _, result := range results {
+		if result.Err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("op on pod %s/%s failed: %w", result.Op.Namespace, result.Op.PodName, result.Err)
+		}
+		if result.Err == nil && !opts.DryRun {
+			succeeded = append(succeeded, result)
+		}
+	}
+	if firstErr == nil || opts.DryRun {
+		return results, firstErr
+	}
+
+	glog.Warningf("[%s] Rolling back %d succeeded op(s) after a batch annotation transaction failure: %s", reqID, len(succeeded), firstErr)
+	batchClient.rollbackBatchAnnotationOps(ctx, reqID, succeeded, opts.MaxRetries)
+	return results, firstErr
+}
+
+// runBatchAnnotationOp snapshots op's pod's current annotations, then either computes
+// (opts.DryRun) or sends the patch reconciling them to op's desired state
+func (c *Client) runBatchAnnotationOp(ctx context.Context, reqID string, op BatchAnnotationOp, opts BatchAnnotationTransactionOptions) BatchAnnotationResult {
+	result := BatchAnnotationResult{Op: op}
+
+	current, err := c.client.CoreV1().Pods(op.Namespace).Get(op.PodName, metav1.GetOptions{})
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Previous = current.Annotations
+
+	if opts.DryRun {
+		_, result.Patch, result.Err = buildAnnotationPatch(reqID, op.PodName, current, op.AddOrUpdate, op.DeleteKeys, patchStrategyFor(c.opts.PatchStrategy))
+		return result
+	}
+
+	_, err = c.UpdateAnnotations(ctx, reqID, op.Namespace, op.PodName, op.AddOrUpdate, op.DeleteKeys)
+	result.Err = err
+	return result
+}
+
+// rollbackBatchAnnotationOps best-effort restores each succeeded result's pre-patch annotations,
+// removing any key the transaction's patch added that was not already present beforehand
+func (c *Client) rollbackBatchAnnotationOps(ctx context.Context, reqID string, succeeded []BatchAnnotationResult, maxRetries int) {
+	var wg sync.WaitGroup
+	for _, result := range succeeded {
+		wg.Add(1)
+		go func(result BatchAnnotationResult) {
+			defer wg.Done()
+
+			current, err := c.client.CoreV1().Pods(result.Op.Namespace).Get(result.Op.PodName, metav1.GetOptions{})
+			if err != nil {
+				glog.Errorf("[%s] Rollback failed to fetch pod %s/%s: %s", reqID, result.Op.Namespace, result.Op.PodName, err)
+				patchRollbacks.WithLabelValues("failure").Inc()
+				return
+			}
+
+			var deleteKeys []string
+			for key := range current.Annotations {
+				if _, ok := result.Previous[key]; !ok {
+					deleteKeys = append(deleteKeys, key)
+				}
+			}
+
+			if _, err := c.UpdateAnnotations(ctx, reqID, result.Op.Namespace, result.Op.PodName, result.Previous, deleteKeys); err != nil {
+				glog.Errorf("[%s] Rollback failed to restore annotations on pod %s/%s: %s", reqID, result.Op.Namespace, result.Op.PodName, err)
+				patchRollbacks.WithLabelValues("failure").Inc()
+				return
+			}
+			patchRollbacks.WithLabelValues("success").Inc()
+		}(result)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package podpatcher
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	guuid "github.com/google/uuid"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+
+	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+)
+
+// namedPodSpec returns a pod named name, scheduled to nodeName, with a small set of
+// pre-existing annotations for tests to assert against
+func namedPodSpec(name string, nodeName string) *v1.Pod {
+	return &v1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Annotations: map[string]string{
+				"init-annotation1": "init-value1",
+				"init-annotation2": "init-value2",
+			},
+		},
+		Spec: v1.PodSpec{NodeName: nodeName},
+	}
+}
+
+// TestBatchUpdateAnnotationsSucceeds tests that every op in a transaction with no failures is
+// applied to its pod
+func TestBatchUpdateAnnotationsSucceeds(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	namespace := "default"
+	podNames := []string{"pod-a", "pod-b", "pod-c"}
+
+	var ops []BatchAnnotationOp
+	for _, name := range podNames {
+		if _, err := client.CoreV1().Pods(namespace).Create(namedPodSpec(name, "node1")); err != nil {
+			t.Fatalf("failed to create pod %s: %s", name, err)
+		}
+		ops = append(ops, BatchAnnotationOp{
+			Namespace:   namespace,
+			PodName:     name,
+			AddOrUpdate: map[string]string{constants.AnnotationExecuteStatus: "active"},
+		})
+	}
+
+	c := NewClient(client, ClientOptions{MaxRetries: 3})
+	results, err := c.BatchUpdateAnnotations(context.Background(), guuid.New().String(), ops, BatchAnnotationTransactionOptions{Concurrency: 2, MaxRetries: 3})
+	if err != nil {
+		t.Fatalf("expected transaction to succeed, got error: %s", err)
+	}
+	if len(results) != len(ops) {
+		t.Fatalf("got %d results, want %d", len(results), len(ops))
+	}
+
+	for i, name := range podNames {
+		if results[i].Err != nil {
+			t.Errorf("result for pod %s has unexpected error: %s", name, results[i].Err)
+		}
+		pod, err := client.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to fetch pod %s: %s", name, err)
+		}
+		if pod.Annotations[constants.AnnotationExecuteStatus] != "active" {
+			t.Errorf("pod %s annotations = %v, want status active", name, pod.Annotations)
+		}
+	}
+}
+
+// TestBatchUpdateAnnotationsRollsBackOnFailure tests that a failing op causes every
+// already-succeeded op's pod to have its original annotations restored
+func TestBatchUpdateAnnotationsRollsBackOnFailure(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	namespace := "default"
+
+	if _, err := client.CoreV1().Pods(namespace).Create(namedPodSpec("good-pod", "node1")); err != nil {
+		t.Fatalf("failed to create good-pod: %s", err)
+	}
+	if _, err := client.CoreV1().Pods(namespace).Create(namedPodSpec("bad-pod", "node1")); err != nil {
+		t.Fatalf("failed to create bad-pod: %s", err)
+	}
+
+	client.PrependReactor("patch", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if action.(ktesting.PatchAction).GetName() == "bad-pod" {
+			return true, nil, apierrors.NewInternalError(fmt.Errorf("simulated failure"))
+		}
+		return false, nil, nil
+	})
+
+	ops := []BatchAnnotationOp{
+		{Namespace: namespace, PodName: "good-pod", AddOrUpdate: map[string]string{constants.AnnotationExecuteStatus: "active"}},
+		{Namespace: namespace, PodName: "bad-pod", AddOrUpdate: map[string]string{constants.AnnotationExecuteStatus: "active"}},
+	}
+
+	c := NewClient(client, ClientOptions{MaxRetries: 1})
+	_, err := c.BatchUpdateAnnotations(context.Background(), guuid.New().String(), ops, BatchAnnotationTransactionOptions{Concurrency: 2, MaxRetries: 1})
+	if err == nil {
+		t.Fatal("expected transaction to fail")
+	}
+
+	goodPod, fetchErr := client.CoreV1().Pods(namespace).Get("good-pod", metav1.GetOptions{})
+	if fetchErr != nil {
+		t.Fatalf("failed to fetch good-pod: %s", fetchErr)
+	}
+	expectedAnnotations := map[string]string{
+		"init-annotation1": "init-value1",
+		"init-annotation2": "init-value2",
+		// the rollback's own compensating patch stashes the annotations it restored under
+		// AnnotationLastApplied, same as any other UpdateAnnotations call
+		constants.AnnotationLastApplied: `{"init-annotation1":"init-value1","init-annotation2":"init-value2"}`,
+	}
+	if !reflect.DeepEqual(goodPod.Annotations, expectedAnnotations) {
+		t.Errorf("good-pod annotations after rollback = %v, want %v", goodPod.Annotations, expectedAnnotations)
+	}
+}
+
+// TestBatchUpdateAnnotationsDryRunDoesNotPatch tests that dry-run mode computes patch bytes
+// without writing anything to the apiserver
+func TestBatchUpdateAnnotationsDryRunDoesNotPatch(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	namespace := "default"
+
+	if _, err := client.CoreV1().Pods(namespace).Create(namedPodSpec("dry-run-pod", "node1")); err != nil {
+		t.Fatalf("failed to create dry-run-pod: %s", err)
+	}
+
+	client.PrependReactor("patch", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		t.Fatal("dry-run should not issue a Patch against the apiserver")
+		return false, nil, nil
+	})
+
+	ops := []BatchAnnotationOp{
+		{Namespace: namespace, PodName: "dry-run-pod", AddOrUpdate: map[string]string{constants.AnnotationExecuteStatus: "active"}},
+	}
+
+	c := NewClient(client, ClientOptions{})
+	results, err := c.BatchUpdateAnnotations(context.Background(), guuid.New().String(), ops, BatchAnnotationTransactionOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("expected dry-run to succeed, got error: %s", err)
+	}
+	if len(results[0].Patch) == 0 {
+		t.Error("expected dry-run result to include computed patch bytes")
+	}
+
+	pod, err := client.CoreV1().Pods(namespace).Get("dry-run-pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to fetch dry-run-pod: %s", err)
+	}
+	if _, ok := pod.Annotations[constants.AnnotationExecuteStatus]; ok {
+		t.Errorf("dry-run should not have modified pod annotations, got %v", pod.Annotations)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package podpatcher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// patchAttempts counts Client.UpdateAnnotations calls, labeled by their final outcome; a
+	// Patch retried after a conflict within one call is not counted again here
+	// (patchConflicts counts those)
+	patchAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "privilege_cmd_controller_pod_patch_attempts_total",
+		Help: "Total number of pod annotation patch attempts, labeled by outcome.",
+	}, []string{"outcome"})
+
+	// patchConflicts counts Patch calls that failed with a Conflict and were retried
+	patchConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "privilege_cmd_controller_pod_patch_conflicts_total",
+		Help: "Total number of pod annotation patches that lost a conflict and were retried.",
+	}, []string{})
+
+	// patchRollbacks counts compensating patches BatchUpdateAnnotations issues to undo an
+	// already-applied op after a later op in the same batch fails
+	patchRollbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "privilege_cmd_controller_pod_patch_rollbacks_total",
+		Help: "Total number of rollback patches issued after a batch annotation transaction failure, labeled by outcome.",
+	}, []string{"outcome"})
+
+	// patchDuration observes how long a single Client.UpdateAnnotations call takes, across all
+	// of its retry attempts
+	patchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "privilege_cmd_controller_pod_patch_duration_seconds",
+		Help:    "Latency of a full Client.UpdateAnnotations call, including any retries.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(patchAttempts, patchConflicts, patchRollbacks, patchDuration)
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package podpatcher
+
+import (
+	"context"
+	"errors"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NodeResolver determines which node a pod is (or will be) running on. It exists as an
+// interface, rather than a free function, so Client can be unit-tested against a fake
+// NodeResolver instead of having to populate a full Node object in the fake clientset for every
+// test that only cares about annotation patching.
+type NodeResolver interface {
+	// ResolveNode returns pod's node name, or an error if it cannot be determined
+	ResolveNode(ctx context.Context, pod *v1.Pod) (string, error)
+}
+
+// nodeByHostIPResolver is the production NodeResolver: it trusts spec.nodeName once the
+// scheduler has bound the pod, and falls back to matching status.hostIP against a Node's
+// v1.NodeInternalIP address for a pod caught mid-scheduling, where spec.nodeName is not yet set
+// but the kubelet has already reported a host IP.
+type nodeByHostIPResolver struct {
+	client kubernetes.Interface
+}
+
+// NewNodeResolver returns the production NodeResolver, backed by client
+func NewNodeResolver(client kubernetes.Interface) NodeResolver {
+	return &nodeByHostIPResolver{client: client}
+}
+
+func (r *nodeByHostIPResolver) ResolveNode(ctx context.Context, pod *v1.Pod) (string, error) {
+	if pod.Spec.NodeName != "" {
+		return pod.Spec.NodeName, nil
+	}
+
+	if pod.Status.HostIP == "" {
+		return "", errors.New("no node name detected for target pod: " + pod.Name)
+	}
+
+	nodes, err := r.client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type == v1.NodeInternalIP && address.Address == pod.Status.HostIP {
+				return node.Name, nil
+			}
+		}
+	}
+	return "", errors.New("no node matches host IP " + pod.Status.HostIP + " for target pod: " + pod.Name)
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package podpatcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestResolveNodeUsesSpecNodeNameWhenSet tests that a scheduled pod's spec.nodeName is trusted
+// directly, without ever consulting the Node list
+func TestResolveNodeUsesSpecNodeNameWhenSet(t *testing.T) {
+	nodeNames := []string{"node1", "node2"}
+	var podList []*v1.Pod
+	for _, nodeName := range nodeNames {
+		podList = append(podList, namedPodSpec("test-pod", nodeName))
+	}
+
+	resolver := NewNodeResolver(fake.NewSimpleClientset())
+	var resolved []string
+	for _, pod := range podList {
+		node, err := resolver.ResolveNode(context.Background(), pod)
+		if err != nil {
+			t.Fatalf("ResolveNode returned error: %s", err)
+		}
+		resolved = append(resolved, node)
+	}
+
+	if !reflect.DeepEqual(resolved, nodeNames) {
+		t.Errorf("resolved node names = %v, want %v", resolved, nodeNames)
+	}
+}
+
+// TestResolveNodeFallsBackToHostIP tests that a pod caught mid-scheduling, with no
+// spec.nodeName yet but a reported status.hostIP, resolves to the Node whose internal IP
+// matches that host IP
+func TestResolveNodeFallsBackToHostIP(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.5"},
+			},
+		},
+	}
+	if _, err := client.CoreV1().Nodes().Create(node); err != nil {
+		t.Fatalf("failed to create node: %s", err)
+	}
+
+	pod := namedPodSpec("test-pod", "")
+	pod.Status.HostIP = "10.0.0.5"
+
+	resolver := NewNodeResolver(client)
+	resolved, err := resolver.ResolveNode(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("ResolveNode returned error: %s", err)
+	}
+	if resolved != "node1" {
+		t.Errorf("resolved node = %q, want %q", resolved, "node1")
+	}
+}
+
+// TestResolveNodeErrorsWhenUnresolvable tests that a pod with neither spec.nodeName nor a
+// status.hostIP matching any known Node returns an error
+func TestResolveNodeErrorsWhenUnresolvable(t *testing.T) {
+	resolver := NewNodeResolver(fake.NewSimpleClientset())
+	pod := namedPodSpec("test-pod", "")
+
+	if _, err := resolver.ResolveNode(context.Background(), pod); err == nil {
+		t.Error("expected ResolveNode to error for a pod with no nodeName or matching hostIP")
+	}
+}
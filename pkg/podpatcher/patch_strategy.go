@@ -0,0 +1,191 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package podpatcher
+
+import (
+	"encoding/json"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+const (
+	// PatchStrategyStrategicMerge computes a three-way strategic merge patch against the
+	// constants.AnnotationLastApplied stash, the same scheme kubectl apply uses. It is the
+	// default and the pre-existing behavior.
+	PatchStrategyStrategicMerge = "strategic-merge"
+	// PatchStrategyJSONPatch emits an RFC 6902 JSON Patch with one add/replace/remove operation
+	// per changed annotation key
+	PatchStrategyJSONPatch = "json-patch"
+	// PatchStrategyJSONMergePatch emits an RFC 7386 JSON Merge Patch setting every desired key and
+	// nulling out removed ones
+	PatchStrategyJSONMergePatch = "json-merge-patch"
+	// PatchStrategyServerSideApply hands the apiserver the full desired set of controller-owned
+	// annotations via a Server-Side Apply patch, so field ownership and conflicts with other
+	// appliers of those same keys are tracked and enforced server-side instead of client-side
+	PatchStrategyServerSideApply = "server-side-apply"
+)
+
+// fieldManager identifies this controller's ownership of the fields it applies via
+// PatchStrategyServerSideApply
+const fieldManager = "privilege-cmd-controller"
+
+// patchStrategy builds the wire-level patch Client.UpdateAnnotations sends to reconcile a pod's
+// annotations to desired; selected by ClientOptions.PatchStrategy
+type patchStrategy interface {
+	// buildPatch returns the PatchType and request body that bring current's annotations to
+	// desired. original is current's annotations as this controller last applied them (see
+	// Client.UpdateAnnotations) rather than current.Annotations itself, so a strategy can tell
+	// a key it owns that dropped out of desired apart from one it has never touched.
+	buildPatch(current *v1.Pod, original, desired map[string]string) (types.PatchType, []byte, error)
+}
+
+// patchStrategyFor resolves name (one of the PatchStrategy* constants) to its patchStrategy,
+// defaulting to PatchStrategyStrategicMerge for an empty or unrecognized name
+func patchStrategyFor(name string) patchStrategy {
+	switch name {
+	case PatchStrategyJSONPatch:
+		return jsonPatchStrategy{}
+	case PatchStrategyJSONMergePatch:
+		return jsonMergePatchStrategy{}
+	case PatchStrategyServerSideApply:
+		return serverSideApplyStrategy{}
+	default:
+		return strategicMergeStrategy{}
+	}
+}
+
+// strategicMergeStrategy is the three-way kubectl-apply-style merge Client.UpdateAnnotations has
+// always performed
+type strategicMergeStrategy struct{}
+
+func (strategicMergeStrategy) buildPatch(current *v1.Pod, original, desired map[string]string) (types.PatchType, []byte, error) {
+	currentBytes, err := json.Marshal(current)
+	if err != nil {
+		return "", nil, err
+	}
+
+	originalPod := current.DeepCopy()
+	originalPod.Annotations = original
+	originalBytes, err := json.Marshal(originalPod)
+	if err != nil {
+		return "", nil, err
+	}
+
+	modifiedPod := current.DeepCopy()
+	modifiedPod.Annotations = desired
+	modifiedBytes, err := json.Marshal(modifiedPod)
+	if err != nil {
+		return "", nil, err
+	}
+
+	patchMeta, err := strategicpatch.NewPatchMetaFromStruct(v1.Pod{})
+	if err != nil {
+		return "", nil, err
+	}
+	patch, err := strategicpatch.CreateThreeWayMergePatch(originalBytes, modifiedBytes, currentBytes, patchMeta, true)
+	if err != nil {
+		return "", nil, err
+	}
+	return types.StrategicMergePatchType, patch, nil
+}
+
+// jsonPatchOp is a single RFC 6902 JSON Patch operation
+type jsonPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// jsonPatchStrategy diffs original against desired directly into explicit add/replace/remove
+// operations, rather than letting the apiserver recompute the merge itself. Unlike the other
+// strategies, it leads with a test op against /metadata/resourceVersion so a competing writer
+// that has touched the pod since current was read makes the whole patch fail instead of silently
+// overwriting that writer's change.
+type jsonPatchStrategy struct{}
+
+func (jsonPatchStrategy) buildPatch(current *v1.Pod, original, desired map[string]string) (types.PatchType, []byte, error) {
+	ops := []jsonPatchOp{
+		{Op: "test", Path: "/metadata/resourceVersion", Value: current.ResourceVersion},
+	}
+	if len(current.Annotations) == 0 && len(desired) > 0 {
+		ops = append(ops, jsonPatchOp{Op: "add", Path: "/metadata/annotations", Value: map[string]string{}})
+	}
+	for key, value := range desired {
+		op := "add"
+		if originalValue, ok := original[key]; ok {
+			if originalValue == value {
+				continue
+			}
+			op = "replace"
+		}
+		ops = append(ops, jsonPatchOp{Op: op, Path: "/metadata/annotations/" + jsonPatchEscape(key), Value: value})
+	}
+	for key := range original {
+		if _, ok := desired[key]; !ok {
+			ops = append(ops, jsonPatchOp{Op: "remove", Path: "/metadata/annotations/" + jsonPatchEscape(key)})
+		}
+	}
+
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return "", nil, err
+	}
+	return types.JSONPatchType, body, nil
+}
+
+// jsonPatchEscape escapes "~" and "/" in a JSON Pointer reference token per RFC 6901, needed for
+// annotation keys carrying a "domain/name" prefix
+func jsonPatchEscape(token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	token = strings.ReplaceAll(token, "/", "~1")
+	return token
+}
+
+// jsonMergePatchStrategy emits an RFC 7386 JSON Merge Patch: every desired key set to its value,
+// changed or not, and keys dropped from desired set to null
+type jsonMergePatchStrategy struct{}
+
+func (jsonMergePatchStrategy) buildPatch(current *v1.Pod, original, desired map[string]string) (types.PatchType, []byte, error) {
+	annotations := map[string]interface{}{}
+	for key, value := range desired {
+		annotations[key] = value
+	}
+	for key := range original {
+		if _, ok := desired[key]; !ok {
+			annotations[key] = nil
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return types.MergePatchType, body, nil
+}
+
+// serverSideApplyStrategy hands the apiserver the full desired set of controller-owned
+// annotations rather than a client-computed diff; the apiserver merges it in and tracks this
+// controller's ownership of those keys under fieldManager
+type serverSideApplyStrategy struct{}
+
+func (serverSideApplyStrategy) buildPatch(current *v1.Pod, original, desired map[string]string) (types.PatchType, []byte, error) {
+	applyPod := v1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        current.Name,
+			Namespace:   current.Namespace,
+			Annotations: desired,
+		},
+	}
+	body, err := json.Marshal(applyPod)
+	if err != nil {
+		return "", nil, err
+	}
+	return types.ApplyPatchType, body, nil
+}
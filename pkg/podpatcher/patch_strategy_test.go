@@ -0,0 +1,128 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package podpatcher
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// TestPatchStrategyForDefaultsToStrategicMerge tests that an empty or unrecognized
+// --patch-strategy value falls back to the pre-existing three-way merge behavior
+func TestPatchStrategyForDefaultsToStrategicMerge(t *testing.T) {
+	for _, name := range []string{"", "bogus-strategy"} {
+		if _, ok := patchStrategyFor(name).(strategicMergeStrategy); !ok {
+			t.Errorf("patchStrategyFor(%q) = %T, want strategicMergeStrategy", name, patchStrategyFor(name))
+		}
+	}
+}
+
+// TestJSONPatchStrategyBuildsAddReplaceRemoveOps tests that jsonPatchStrategy emits one
+// operation per changed annotation key and the right op for each
+func TestJSONPatchStrategyBuildsAddReplaceRemoveOps(t *testing.T) {
+	current := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			ResourceVersion: "42",
+			Annotations:     map[string]string{"keep": "same", "update-me": "old", "remove-me": "gone"},
+		},
+	}
+	original := map[string]string{"keep": "same", "update-me": "old", "remove-me": "gone"}
+	desired := map[string]string{"keep": "same", "update-me": "new", "add-me": "added"}
+
+	patchType, body, err := jsonPatchStrategy{}.buildPatch(current, original, desired)
+	if err != nil {
+		t.Fatalf("buildPatch returned error: %s", err)
+	}
+	if patchType != types.JSONPatchType {
+		t.Errorf("patchType = %v, want %v", patchType, types.JSONPatchType)
+	}
+
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(body, &ops); err != nil {
+		t.Fatalf("failed to unmarshal patch body: %s", err)
+	}
+
+	byPath := map[string]jsonPatchOp{}
+	for _, op := range ops {
+		byPath[op.Path] = op
+	}
+	if op := byPath["/metadata/annotations/update-me"]; op.Op != "replace" || op.Value != "new" {
+		t.Errorf("update-me op = %+v, want replace to \"new\"", op)
+	}
+	if op := byPath["/metadata/annotations/add-me"]; op.Op != "add" || op.Value != "added" {
+		t.Errorf("add-me op = %+v, want add of \"added\"", op)
+	}
+	if op := byPath["/metadata/annotations/remove-me"]; op.Op != "remove" {
+		t.Errorf("remove-me op = %+v, want remove", op)
+	}
+	if _, ok := byPath["/metadata/annotations/keep"]; ok {
+		t.Errorf("unchanged annotation \"keep\" should not produce an op, got %+v", byPath["/metadata/annotations/keep"])
+	}
+	if op := byPath["/metadata/resourceVersion"]; op.Op != "test" || op.Value != "42" {
+		t.Errorf("resourceVersion guard op = %+v, want test against \"42\"", op)
+	}
+	if ops[0].Path != "/metadata/resourceVersion" {
+		t.Errorf("resourceVersion test op must lead the patch so a stale read fails before any mutation, got ops[0] = %+v", ops[0])
+	}
+}
+
+// TestJSONMergePatchStrategyNullsRemovedKeys tests that jsonMergePatchStrategy sets every
+// desired key and nulls out keys dropped from desired
+func TestJSONMergePatchStrategyNullsRemovedKeys(t *testing.T) {
+	current := &v1.Pod{}
+	original := map[string]string{"keep": "same", "remove-me": "gone"}
+	desired := map[string]string{"keep": "same", "add-me": "added"}
+
+	patchType, body, err := jsonMergePatchStrategy{}.buildPatch(current, original, desired)
+	if err != nil {
+		t.Fatalf("buildPatch returned error: %s", err)
+	}
+	if patchType != types.MergePatchType {
+		t.Errorf("patchType = %v, want %v", patchType, types.MergePatchType)
+	}
+
+	var decoded struct {
+		Metadata struct {
+			Annotations map[string]interface{} `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal patch body: %s", err)
+	}
+
+	expected := map[string]interface{}{"keep": "same", "add-me": "added", "remove-me": nil}
+	if !reflect.DeepEqual(decoded.Metadata.Annotations, expected) {
+		t.Errorf("annotations = %v, want %v", decoded.Metadata.Annotations, expected)
+	}
+}
+
+// TestServerSideApplyStrategyAppliesOnlyDesired tests that serverSideApplyStrategy submits the
+// desired annotation set for this pod's name and namespace using the Apply patch type
+func TestServerSideApplyStrategyAppliesOnlyDesired(t *testing.T) {
+	current := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"}}
+	desired := map[string]string{"keep": "same", "add-me": "added"}
+
+	patchType, body, err := serverSideApplyStrategy{}.buildPatch(current, nil, desired)
+	if err != nil {
+		t.Fatalf("buildPatch returned error: %s", err)
+	}
+	if patchType != types.ApplyPatchType {
+		t.Errorf("patchType = %v, want %v", patchType, types.ApplyPatchType)
+	}
+
+	var applied v1.Pod
+	if err := json.Unmarshal(body, &applied); err != nil {
+		t.Fatalf("failed to unmarshal patch body: %s", err)
+	}
+	if applied.Name != "test-pod" || applied.Namespace != "default" {
+		t.Errorf("applied pod identity = %s/%s, want default/test-pod", applied.Namespace, applied.Name)
+	}
+	if !reflect.DeepEqual(applied.Annotations, desired) {
+		t.Errorf("applied annotations = %v, want %v", applied.Annotations, desired)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+
+// Package podpatcher patches pod annotations and resolves the node a pod runs on, on behalf of
+// pkg/privexecutor. It is split out as its own package so both concerns can be exercised against
+// a fake kubernetes.Interface (k8s.io/client-go/kubernetes/fake) without pulling in the rest of
+// the executor, and so every method takes a context.Context that bounds how long it may run -
+// the pkg/privexecutor functions it replaces took none, and so could hang indefinitely against a
+// slow or unreachable apiserver.
+package podpatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+)
+
+// PodPatcher updates pod annotations and resolves the node a pod runs on. Client is the
+// production implementation, backed by a real kubernetes.Interface; tests construct a Client
+// over k8s.io/client-go/kubernetes/fake instead of needing a real cluster.
+type PodPatcher interface {
+	// UpdateAnnotations adds or updates addOrUpdate and removes deleteKeys on podName's
+	// controller-owned annotations, returning the pod as patched. reqID is used only to
+	// prefix log lines.
+	UpdateAnnotations(ctx context.Context, reqID string, namespace string, podName string, addOrUpdate map[string]string, deleteKeys []string) (*v1.Pod, error)
+	// NodeName resolves the node pod is (or will be) scheduled to
+	NodeName(ctx context.Context, pod *v1.Pod) (string, error)
+	// BatchUpdateAnnotations runs ops concurrently, bounded by opts.Concurrency, rolling back
+	// already-applied ops if any op fails
+	BatchUpdateAnnotations(ctx context.Context, reqID string, ops []BatchAnnotationOp, opts BatchAnnotationTransactionOptions) ([]BatchAnnotationResult, error)
+}
+
+// ClientOptions configures a Client
+type ClientOptions struct {
+	// PatchStrategy selects how the wire-level patch is built; see the PatchStrategy* constants.
+	// Empty defaults to PatchStrategyStrategicMerge.
+	PatchStrategy string
+	// MaxRetries bounds how many attempts, the first included, UpdateAnnotations makes at a
+	// patch that keeps losing a Conflict; <= 0 is treated as a single attempt, no retries
+	MaxRetries int
+}
+
+// Client is the production PodPatcher, backed by client
+type Client struct {
+	client       kubernetes.Interface
+	opts         ClientOptions
+	nodeResolver NodeResolver
+}
+
+// NewClient returns a Client backed by client and opts
+func NewClient(client kubernetes.Interface, opts ClientOptions) *Client {
+	return &Client{client: client, opts: opts, nodeResolver: NewNodeResolver(client)}
+}
+
+// NodeName resolves pod's node via this Client's NodeResolver
+func (c *Client) NodeName(ctx context.Context, pod *v1.Pod) (string, error) {
+	return c.nodeResolver.ResolveNode(ctx, pod)
+}
+
+// UpdateAnnotations adds or updates addOrUpdate and removes deleteKeys on podName's
+// controller-owned annotations. Unlike a plain Get-then-Patch, it always recomputes the patch
+// against a freshly fetched copy of the pod rather than one a caller may already be holding, so a
+// concurrent update from another controller (kubelet, scheduler, a CNI) between that caller's Get
+// and our Patch cannot be silently clobbered; a Patch that loses that race with a Conflict
+// re-reads the pod and retries, up to opts.MaxRetries attempts. The whole retry loop runs on a
+// background goroutine so that a cancelled or expired ctx returns promptly instead of waiting out
+// whatever attempt is in flight against a slow apiserver.
+//
+// The patch is built by c.opts.PatchStrategy (see patch_strategy.go) from the set of annotations
+// this controller last applied (stashed under constants.AnnotationLastApplied), the newly
+// desired set, and the pod as it currently exists on the server. The default strategy,
+// PatchStrategyStrategicMerge, is a three-way merge - the same scheme kubectl apply uses - which
+// lets a previously applied annotation that drops out of the desired set be correctly removed
+// even if the live pod has since changed, without touching annotations owned by anyone else. It
+// returns the pod as patched.
+func (c *Client) UpdateAnnotations(ctx context.Context, reqID string, namespace string, podName string, addOrUpdate map[string]string, deleteKeys []string) (*v1.Pod, error) {
+	start := time.Now()
+	patched, err := c.updateAnnotations(ctx, reqID, namespace, podName, addOrUpdate, deleteKeys)
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	patchAttempts.WithLabelValues(outcome).Inc()
+	patchDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	return patched, err
+}
+
+func (c *Client) updateAnnotations(ctx context.Context, reqID string, namespace string, podName string, addOrUpdate map[string]string, deleteKeys []string) (*v1.Pod, error) {
+	steps := c.opts.MaxRetries
+	if steps <= 0 {
+		steps = 1
+	}
+	backoff := retry.DefaultBackoff
+	backoff.Steps = steps
+	strategy := patchStrategyFor(c.opts.PatchStrategy)
+
+	type result struct {
+		pod *v1.Pod
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var patched *v1.Pod
+		err := retry.RetryOnConflict(backoff, func() error {
+			current, err := c.client.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			patchType, patch, err := buildAnnotationPatch(reqID, podName, current, addOrUpdate, deleteKeys, strategy)
+			if err != nil {
+				return err
+			}
+
+			patched, err = applyPatch(c.client, namespace, podName, patchType, patch)
+			if apierrors.IsConflict(err) {
+				patchConflicts.WithLabelValues().Inc()
+			}
+			return err
+		})
+		if err != nil {
+			done <- result{err: fmt.Errorf("failed to patch annotations on pod %s: %s", podName, err)}
+			return
+		}
+		glog.Infof("[%s] Completed patching annotations on pod %s", reqID, podName)
+		done <- result{pod: patched}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("patching annotations on pod %s cancelled: %s", podName, ctx.Err())
+	case r := <-done:
+		return r.pod, r.err
+	}
+}
+
+// buildAnnotationPatch computes the PatchType and request body that reconcile current's
+// controller-owned annotations to add addOrUpdate and remove deleteKeys, via strategy. It holds
+// the lastApplied bookkeeping shared by every patchStrategy and is split out of
+// updateAnnotations so BatchUpdateAnnotations's dry-run mode can compute the same patch without
+// sending it to the apiserver.
+func buildAnnotationPatch(reqID string, podName string, current *v1.Pod, addOrUpdate map[string]string, deleteKeys []string, strategy patchStrategy) (types.PatchType, []byte, error) {
+	lastApplied := map[string]string{}
+	if raw, ok := current.Annotations[constants.AnnotationLastApplied]; ok {
+		if err := json.Unmarshal([]byte(raw), &lastApplied); err != nil {
+			glog.Warningf("[%s] Failed to parse %s annotation on pod %s, treating last-applied set as empty: %v", reqID, constants.AnnotationLastApplied, podName, err)
+			lastApplied = map[string]string{}
+		}
+	}
+
+	// original reflects the pod as this controller last saw it: the live annotations, but
+	// with any key it previously applied pinned back to the value it applied, rather than
+	// whatever that key may have drifted to on the server since. That's what lets the
+	// eventual three-way diff tell "a key we own disappeared from our desired set" (delete
+	// it) apart from "a key we've never touched" (leave it alone, however it drifted).
+	originalAnnotations := map[string]string{}
+	for key, value := range current.Annotations {
+		originalAnnotations[key] = value
+	}
+	for key, value := range lastApplied {
+		originalAnnotations[key] = value
+	}
+
+	desired := map[string]string{}
+	for key, value := range originalAnnotations {
+		desired[key] = value
+	}
+	for key, value := range addOrUpdate {
+		desired[key] = value
+	}
+	newLastApplied := map[string]string{}
+	for key, value := range lastApplied {
+		newLastApplied[key] = value
+	}
+	for key, value := range addOrUpdate {
+		newLastApplied[key] = value
+	}
+	for _, key := range deleteKeys {
+		if _, ok := desired[key]; !ok {
+			glog.Warningf("[%s] Annotation %s already absent from pod %s, nothing to delete", reqID, key, podName)
+			continue
+		}
+		delete(desired, key)
+		delete(newLastApplied, key)
+	}
+	// With nothing left that this controller owns, no stash is kept: an existing one drops out
+	// of desired and is removed, and none is added to a pod that had none
+	if len(newLastApplied) > 0 {
+		newLastAppliedBytes, err := json.Marshal(newLastApplied)
+		if err != nil {
+			return "", nil, err
+		}
+		desired[constants.AnnotationLastApplied] = string(newLastAppliedBytes)
+	}
+
+	return strategy.buildPatch(current, originalAnnotations, desired)
+}
+
+// applyPatch sends patch to the apiserver. The typed Pods() client's Patch method has no way to
+// set a field manager, so a PatchStrategyServerSideApply patch goes through the REST client
+// directly with one attached as a query param instead; every other patch type goes through the
+// typed client as before.
+func applyPatch(client kubernetes.Interface, namespace string, podName string, patchType types.PatchType, patch []byte) (*v1.Pod, error) {
+	if patchType != types.ApplyPatchType {
+		return client.CoreV1().Pods(namespace).Patch(podName, patchType, patch)
+	}
+
+	result := &v1.Pod{}
+	err := client.CoreV1().RESTClient().Patch(patchType).
+		Namespace(namespace).
+		Resource("pods").
+		Name(podName).
+		Param("fieldManager", fieldManager).
+		Body(patch).
+		Do().
+		Into(result)
+	return result, err
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package podpatcher
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	guuid "github.com/google/uuid"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+
+	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+)
+
+// TestUpdateAnnotationsRecoversFromConflict tests that a Patch which loses a race against
+// another controller's concurrent update is retried against a freshly re-fetched pod rather
+// than failing outright
+func TestUpdateAnnotationsRecoversFromConflict(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	namespace := "default"
+
+	pod, _ := client.CoreV1().Pods(namespace).Create(namedPodSpec("test-pod", "targetNode"))
+
+	var patchAttempts int32
+	client.PrependReactor("patch", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if atomic.AddInt32(&patchAttempts, 1) == 1 {
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, pod.Name, fmt.Errorf("fake concurrent update"))
+		}
+		return false, nil, nil
+	})
+
+	c := NewClient(client, ClientOptions{MaxRetries: 3})
+	annotationToAdd := map[string]string{constants.AnnotationExecuteStatus: "active"}
+	patched, err := c.UpdateAnnotations(context.Background(), guuid.New().String(), namespace, pod.Name, annotationToAdd, nil)
+	if err != nil {
+		t.Fatalf("Expected UpdateAnnotations to recover from the conflict, got error: %s", err)
+	}
+	if patched.Annotations[constants.AnnotationExecuteStatus] != "active" {
+		t.Errorf("Expected annotation %s to be active, got: %v", constants.AnnotationExecuteStatus, patched.Annotations)
+	}
+	if attempts := atomic.LoadInt32(&patchAttempts); attempts < 2 {
+		t.Errorf("Expected UpdateAnnotations to retry past the conflict; only saw %d patch attempts", attempts)
+	}
+}
+
+// TestUpdateAnnotationsRespectsCancelledContext tests that UpdateAnnotations returns promptly
+// once ctx is done, rather than waiting out a Patch call stalled against the apiserver
+func TestUpdateAnnotationsRespectsCancelledContext(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	namespace := "default"
+
+	pod, _ := client.CoreV1().Pods(namespace).Create(namedPodSpec("test-pod", "targetNode"))
+
+	unblock := make(chan struct{})
+	client.PrependReactor("patch", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		<-unblock
+		return false, nil, nil
+	})
+	defer close(unblock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := NewClient(client, ClientOptions{MaxRetries: 1})
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.UpdateAnnotations(ctx, guuid.New().String(), namespace, pod.Name, map[string]string{constants.AnnotationExecuteStatus: "active"}, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected UpdateAnnotations to return an error once ctx was cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("UpdateAnnotations did not return promptly after ctx was cancelled")
+	}
+}
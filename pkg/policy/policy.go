@@ -0,0 +1,173 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+
+// Package policy decides whether a privileged command is allowed to run against a given
+// container before executeNsenterCommand ever touches it. It supports a static YAML
+// allow/deny list keyed by namespace and image, and an optional OPA/Rego evaluator for
+// richer policy.
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Input describes the privileged command a policy decision is being made about
+type Input struct {
+	Namespace   string
+	Image       string
+	Pod         string
+	Container   string
+	ContainerID string
+	Action      string
+	User        string
+}
+
+// Decision is the outcome of evaluating an Input against a policy
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// Evaluator decides whether an Input is permitted
+type Evaluator interface {
+	Evaluate(input Input) (Decision, error)
+}
+
+// NodeLimiter is implemented by Evaluators that additionally cap how many privileged commands
+// may run concurrently on a single node; handleActiveStatus type-asserts for it and applies no cap
+// when an Evaluator does not implement it
+type NodeLimiter interface {
+	// MaxConcurrentPerNode returns the cap, or 0 for no cap
+	MaxConcurrentPerNode() int
+}
+
+// Rule is a single allow or deny entry scoped to a namespace and/or image
+type Rule struct {
+	Namespace string `yaml:"namespace"`
+	Image     string `yaml:"image"`
+	// Commands lists allowed/denied argv[0] values, e.g. "gcore", "tcpdump"
+	Commands []string `yaml:"commands"`
+	// ActionPattern is an optional regex matched against the full action string
+	ActionPattern string `yaml:"actionPattern"`
+	// Requesters, if set, restricts the rule to these privileged-command-requester identities
+	// (user names or impersonated service accounts); unset matches any requester
+	Requesters []string `yaml:"requesters"`
+}
+
+// StaticPolicy is the YAML-loaded allow/deny policy evaluated by StaticEvaluator
+type StaticPolicy struct {
+	Allow []Rule `yaml:"allow"`
+	Deny  []Rule `yaml:"deny"`
+	// MaxConcurrentPerNode caps how many privileged commands may run at once on a single node,
+	// or 0 for no cap
+	MaxConcurrentPerNode int `yaml:"maxConcurrentPerNode"`
+}
+
+// StaticEvaluator evaluates an Input against a StaticPolicy loaded from --policy-file
+type StaticEvaluator struct {
+	policy StaticPolicy
+}
+
+// LoadStaticEvaluator reads and parses the YAML policy file at path
+func LoadStaticEvaluator(path string) (*StaticEvaluator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %s", path, err)
+	}
+
+	var policy StaticPolicy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %s", path, err)
+	}
+	return &StaticEvaluator{policy: policy}, nil
+}
+
+// Evaluate denies input if it matches any deny rule, then allows it only if it matches an
+// allow rule; an empty allow list is treated as allow-all, matching the deny rules only
+func (e *StaticEvaluator) Evaluate(input Input) (Decision, error) {
+	for _, rule := range e.policy.Deny {
+		matched, err := ruleMatches(rule, input)
+		if err != nil {
+			return Decision{}, err
+		}
+		if matched {
+			return Decision{Allowed: false, Reason: fmt.Sprintf("action %q on image %q in namespace %q is explicitly denied", input.Action, input.Image, input.Namespace)}, nil
+		}
+	}
+
+	if len(e.policy.Allow) == 0 {
+		return Decision{Allowed: true}, nil
+	}
+
+	for _, rule := range e.policy.Allow {
+		matched, err := ruleMatches(rule, input)
+		if err != nil {
+			return Decision{}, err
+		}
+		if matched {
+			return Decision{Allowed: true}, nil
+		}
+	}
+	return Decision{Allowed: false, Reason: fmt.Sprintf("action %q on image %q in namespace %q matches no allow rule", input.Action, input.Image, input.Namespace)}, nil
+}
+
+// ruleMatches checks whether input's namespace, image, requester, argv[0], and full action match rule
+func ruleMatches(rule Rule, input Input) (bool, error) {
+	if rule.Namespace != "" && rule.Namespace != input.Namespace {
+		return false, nil
+	}
+	if rule.Image != "" && rule.Image != input.Image {
+		return false, nil
+	}
+	if len(rule.Requesters) > 0 && !containsString(rule.Requesters, input.User) {
+		return false, nil
+	}
+
+	argv0 := argv0Of(input.Action)
+	for _, cmd := range rule.Commands {
+		if cmd == argv0 {
+			return true, nil
+		}
+	}
+
+	if rule.ActionPattern != "" {
+		matched, err := regexp.MatchString(rule.ActionPattern, input.Action)
+		if err != nil {
+			return false, fmt.Errorf("invalid actionPattern %q: %s", rule.ActionPattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// argv0Of returns the first whitespace-delimited token of action
+func argv0Of(action string) string {
+	for i, r := range action {
+		if r == ' ' || r == '\t' {
+			return action[:i]
+		}
+	}
+	return action
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxConcurrentPerNode implements NodeLimiter from the StaticPolicy's maxConcurrentPerNode field
+func (e *StaticEvaluator) MaxConcurrentPerNode() int {
+	return e.policy.MaxConcurrentPerNode
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package policy
+
+import "testing"
+
+// TestStaticEvaluator_DenyTakesPriorityOverAllow tests that a deny rule match is returned
+// even when an allow rule would otherwise also match the same input
+func TestStaticEvaluator_DenyTakesPriorityOverAllow(t *testing.T) {
+	e := &StaticEvaluator{policy: StaticPolicy{
+		Allow: []Rule{{Commands: []string{"gcore"}}},
+		Deny:  []Rule{{Namespace: "prod", Commands: []string{"gcore"}}},
+	}}
+
+	decision, err := e.Evaluate(Input{Namespace: "prod", Action: "gcore 1"})
+	if err != nil {
+		t.Fatalf("Evaluate returned error: %s", err)
+	}
+	if decision.Allowed {
+		t.Errorf("decision = %+v, want denied", decision)
+	}
+}
+
+// TestStaticEvaluator_EmptyAllowListAllowsAll tests that a policy with no allow rules
+// permits anything not explicitly denied
+func TestStaticEvaluator_EmptyAllowListAllowsAll(t *testing.T) {
+	e := &StaticEvaluator{policy: StaticPolicy{
+		Deny: []Rule{{Commands: []string{"rm"}}},
+	}}
+
+	decision, err := e.Evaluate(Input{Action: "tcpdump -i eth0"})
+	if err != nil {
+		t.Fatalf("Evaluate returned error: %s", err)
+	}
+	if !decision.Allowed {
+		t.Errorf("decision = %+v, want allowed", decision)
+	}
+}
+
+// TestStaticEvaluator_NonEmptyAllowListRequiresMatch tests that once an allow list is
+// configured, an input matching no allow rule is denied
+func TestStaticEvaluator_NonEmptyAllowListRequiresMatch(t *testing.T) {
+	e := &StaticEvaluator{policy: StaticPolicy{
+		Allow: []Rule{{Commands: []string{"gcore"}}},
+	}}
+
+	decision, err := e.Evaluate(Input{Action: "strace -p 1"})
+	if err != nil {
+		t.Fatalf("Evaluate returned error: %s", err)
+	}
+	if decision.Allowed {
+		t.Errorf("decision = %+v, want denied", decision)
+	}
+}
+
+// TestRuleMatches covers each field ruleMatches considers, in isolation
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		input Input
+		want  bool
+	}{
+		{
+			name:  "namespace mismatch",
+			rule:  Rule{Namespace: "prod", Commands: []string{"gcore"}},
+			input: Input{Namespace: "staging", Action: "gcore 1"},
+			want:  false,
+		},
+		{
+			name:  "namespace match",
+			rule:  Rule{Namespace: "prod", Commands: []string{"gcore"}},
+			input: Input{Namespace: "prod", Action: "gcore 1"},
+			want:  true,
+		},
+		{
+			name:  "image mismatch",
+			rule:  Rule{Image: "app:v1", Commands: []string{"gcore"}},
+			input: Input{Image: "app:v2", Action: "gcore 1"},
+			want:  false,
+		},
+		{
+			name:  "image match",
+			rule:  Rule{Image: "app:v1", Commands: []string{"gcore"}},
+			input: Input{Image: "app:v1", Action: "gcore 1"},
+			want:  true,
+		},
+		{
+			name:  "requester not in allow-list",
+			rule:  Rule{Requesters: []string{"alice"}, Commands: []string{"gcore"}},
+			input: Input{User: "mallory", Action: "gcore 1"},
+			want:  false,
+		},
+		{
+			name:  "requester in allow-list",
+			rule:  Rule{Requesters: []string{"alice", "bob"}, Commands: []string{"gcore"}},
+			input: Input{User: "bob", Action: "gcore 1"},
+			want:  true,
+		},
+		{
+			name:  "unset requesters matches any user",
+			rule:  Rule{Commands: []string{"gcore"}},
+			input: Input{User: "anyone", Action: "gcore 1"},
+			want:  true,
+		},
+		{
+			name:  "argv0 not in commands",
+			rule:  Rule{Commands: []string{"gcore", "tcpdump"}},
+			input: Input{Action: "rm -rf /"},
+			want:  false,
+		},
+		{
+			name:  "argv0 in commands",
+			rule:  Rule{Commands: []string{"gcore", "tcpdump"}},
+			input: Input{Action: "tcpdump -i eth0"},
+			want:  true,
+		},
+		{
+			name:  "actionPattern matches full action",
+			rule:  Rule{ActionPattern: `^tcpdump -i eth[0-9]+$`},
+			input: Input{Action: "tcpdump -i eth0"},
+			want:  true,
+		},
+		{
+			name:  "actionPattern does not match",
+			rule:  Rule{ActionPattern: `^tcpdump -i eth[0-9]+$`},
+			input: Input{Action: "tcpdump -i wlan0"},
+			want:  false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ruleMatches(tc.rule, tc.input)
+			if err != nil {
+				t.Fatalf("ruleMatches returned error: %s", err)
+			}
+			if got != tc.want {
+				t.Errorf("ruleMatches(%+v, %+v) = %v, want %v", tc.rule, tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestRuleMatches_InvalidActionPattern tests that an unparseable actionPattern surfaces as an
+// error rather than silently matching or not matching
+func TestRuleMatches_InvalidActionPattern(t *testing.T) {
+	_, err := ruleMatches(Rule{ActionPattern: "("}, Input{Action: "gcore 1"})
+	if err == nil {
+		t.Fatal("ruleMatches with an invalid actionPattern returned no error")
+	}
+}
+
+// TestStaticEvaluator_MaxConcurrentPerNode tests that MaxConcurrentPerNode surfaces the
+// loaded policy's cap
+func TestStaticEvaluator_MaxConcurrentPerNode(t *testing.T) {
+	e := &StaticEvaluator{policy: StaticPolicy{MaxConcurrentPerNode: 3}}
+	if got := e.MaxConcurrentPerNode(); got != 3 {
+		t.Errorf("MaxConcurrentPerNode() = %d, want 3", got)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// defaultAllowQuery is the Rego query evaluated against the loaded policy's data.pcc.allow rule
+const defaultAllowQuery = "data.pcc.allow"
+
+// RegoEvaluator evaluates an Input against a Rego policy module's data.pcc.allow rule, returning
+// allow/deny; a denial carries a fixed reason, and no rule of the module is queried for one
+type RegoEvaluator struct {
+	query rego.PreparedEvalQuery
+}
+
+// NewRegoEvaluator compiles the Rego module source into a RegoEvaluator
+func NewRegoEvaluator(ctx context.Context, module string) (*RegoEvaluator, error) {
+	query, err := rego.New(
+		rego.Query(defaultAllowQuery),
+		rego.Module("pcc.rego", module),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile rego policy module: %s", err)
+	}
+	return &RegoEvaluator{query: query}, nil
+}
+
+// Evaluate runs the compiled query against input, passed to Rego as {pod, container, containerID, action, user, namespace}
+func (e *RegoEvaluator) Evaluate(input Input) (Decision, error) {
+	results, err := e.query.Eval(context.Background(), rego.EvalInput(map[string]interface{}{
+		"pod":         input.Pod,
+		"container":   input.Container,
+		"containerID": input.ContainerID,
+		"action":      input.Action,
+		"user":        input.User,
+		"namespace":   input.Namespace,
+	}))
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to evaluate rego policy: %s", err)
+	}
+
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return Decision{Allowed: false, Reason: "rego policy produced no result for data.pcc.allow"}, nil
+	}
+
+	allowed, ok := results[0].Expressions[0].Value.(bool)
+	if !ok {
+		return Decision{}, fmt.Errorf("data.pcc.allow did not evaluate to a boolean")
+	}
+	if !allowed {
+		return Decision{Allowed: false, Reason: "denied by rego policy"}, nil
+	}
+	return Decision{Allowed: true}, nil
+}
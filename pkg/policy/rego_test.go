@@ -0,0 +1,60 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package policy
+
+import (
+	"context"
+	"testing"
+)
+
+const testRegoModule = `
+package pcc
+
+default allow = false
+
+allow {
+	input.namespace == "staging"
+}
+
+allow {
+	input.action == "tcpdump -i eth0"
+}
+`
+
+func TestRegoEvaluator_Evaluate(t *testing.T) {
+	e, err := NewRegoEvaluator(context.Background(), testRegoModule)
+	if err != nil {
+		t.Fatalf("NewRegoEvaluator returned error: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		input Input
+		want  bool
+	}{
+		{name: "allowed by namespace rule", input: Input{Namespace: "staging", Action: "rm -rf /"}, want: true},
+		{name: "allowed by action rule", input: Input{Namespace: "prod", Action: "tcpdump -i eth0"}, want: true},
+		{name: "denied by default", input: Input{Namespace: "prod", Action: "rm -rf /"}, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decision, err := e.Evaluate(tc.input)
+			if err != nil {
+				t.Fatalf("Evaluate returned error: %s", err)
+			}
+			if decision.Allowed != tc.want {
+				t.Errorf("decision = %+v, want Allowed=%v", decision, tc.want)
+			}
+		})
+	}
+}
+
+// TestNewRegoEvaluator_InvalidModule tests that a Rego module with a syntax error is rejected
+// at compile time rather than surfacing as an Evaluate-time error
+func TestNewRegoEvaluator_InvalidModule(t *testing.T) {
+	_, err := NewRegoEvaluator(context.Background(), "this is not valid rego")
+	if err == nil {
+		t.Fatal("NewRegoEvaluator with an invalid module returned no error")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package privexecutor
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// auditRecord is one JSON line written to --auditLogPath (or stdout, by default) per processed
+// privileged command request. It is independent of the PrivCommand* Events recorded on the
+// target pod, so the request can still be reconstructed once the target pod and its Events are
+// gone.
+type auditRecord struct {
+	ReqID           string    `json:"reqID"`
+	Timestamp       time.Time `json:"timestamp"`
+	Requester       string    `json:"requester"`
+	TargetPod       string    `json:"targetPod"`
+	TargetContainer string    `json:"targetContainer"`
+	Node            string    `json:"node,omitempty"`
+	Action          string    `json:"action"`
+	Args            []string  `json:"args,omitempty"`
+	Outcome         string    `json:"outcome"`
+	DurationMs      int64     `json:"durationMs"`
+}
+
+// writeAudit marshals rec as a single JSON line to pc.auditWriter, defaulting to os.Stdout when
+// pc.auditWriter is unset (as it is for a privilegeCmdController built directly by tests)
+func (pc *privilegeCmdController) writeAudit(rec auditRecord) {
+	w := io.Writer(os.Stdout)
+	if pc.auditWriter != nil {
+		w = pc.auditWriter
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		glog.Errorf("[%s] Failed to marshal audit record: %v", rec.ReqID, err)
+		return
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		glog.Errorf("[%s] Failed to write audit record: %v", rec.ReqID, err)
+	}
+}
@@ -3,44 +3,93 @@
 package privexecutor
 
 import (
+	"context"
 	"flag"
-	"fmt"
-	"strings"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
 
 	"github.com/golang/glog"
 	guuid "github.com/google/uuid"
+	"github.com/yahoo/privilege-cmd-controller/pkg/agent"
 	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+	versioned "github.com/yahoo/privilege-cmd-controller/pkg/generated/clientset/versioned"
+	"github.com/yahoo/privilege-cmd-controller/pkg/policy"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 // cmdArgs collects variables from command line arguments
 type cmdArgs struct {
-	PrivPodTimeout int
-	Namespace      string
-	Image          string
-	Serviceaccount string
+	PrivPodTimeout   int
+	Namespace        string
+	Image            string
+	Serviceaccount   string
+	AgentMode        string
+	ContainerRuntime string
+	APIMode          string
+	PolicyFile       string
+	PolicyRegoFile   string
+	MaxRetries       int
+	RetryMaxElapsed  time.Duration
+	AuditLogPath     string
+	PatchStrategy    string
+	AgentLeaseMaxAge time.Duration
 }
 
 // privilegeCmdController contains necessary variables for making client calls
 type privilegeCmdController struct {
-	Controller cache.Controller
-	client     kubernetes.Interface
-	restConfig *rest.Config
+	Controller      cache.Controller
+	client          kubernetes.Interface
+	restConfig      *rest.Config
+	agentPool       *agent.Pool
+	policyEvaluator policy.Evaluator
+	nodeConcurrency *nodeConcurrency
+	retryQueue      *retryQueue
+	// crdClient is the deprecated annotation->PrivilegeCommand compatibility shim's client. A nil
+	// crdClient (as when the client could not be built from restConfig, or in tests that build
+	// privilegeCmdController directly) makes shimAnnotationToCRD a no-op.
+	crdClient versioned.Interface
+	// eventRecorder emits the PrivCommand*/PrivPod* audit Events on the target pod. A nil
+	// eventRecorder (as in tests that build privilegeCmdController directly) is a no-op.
+	eventRecorder record.EventRecorder
+	// auditWriter is where writeAudit appends its JSON audit lines; nil defaults to os.Stdout
+	auditWriter io.Writer
+	// ctx is cancelled when this replica loses leadership, stopping the informer, the retry
+	// worker and any in-flight privileged pod watch started on its behalf
+	ctx context.Context
 }
 
+const (
+	// AgentModeOnDemand creates and deletes a privileged pod per request, matching the pre-existing behavior
+	AgentModeOnDemand = "ondemand"
+	// AgentModeDaemonSet reuses a long-lived per-node agent pod managed by pkg/agent
+	AgentModeDaemonSet = "daemonset"
+
+	// APIModeAnnotations drives requests from the privileged-command-* pod annotations (default, pre-existing behavior)
+	APIModeAnnotations = "annotations"
+	// APIModeCRD drives requests from PrivilegeCommand custom resources via pkg/controller
+	APIModeCRD = "crd"
+)
+
 var (
 	//CmdArgs initializes a global cmdArgs variable
 	CmdArgs cmdArgs
 )
 
-// NewPrivilegeCmdController returns a privilegeCmdController struct with the controller and client
-func NewPrivilegeCmdController(client kubernetes.Interface, restConfig *rest.Config) *privilegeCmdController {
+// NewPrivilegeCmdController returns a privilegeCmdController struct with the controller and client.
+// ctx should be cancelled when this replica loses leadership (see main.go's leader election
+// wiring); Controller.Run and StartRetryWorker both stop as soon as ctx is done.
+func NewPrivilegeCmdController(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config) *privilegeCmdController {
 	glog.Info("Initiating new privilege command controller")
 
 	// Collect command line arguments
@@ -48,11 +97,82 @@ func NewPrivilegeCmdController(client kubernetes.Interface, restConfig *rest.Con
 	CmdArgs.Namespace = flag.Lookup("namespace").Value.(flag.Getter).Get().(string)
 	CmdArgs.Image = flag.Lookup("privilegePodImage").Value.(flag.Getter).Get().(string)
 	CmdArgs.Serviceaccount = flag.Lookup("serviceaccount").Value.(flag.Getter).Get().(string)
+	CmdArgs.AgentMode = flag.Lookup("agent-mode").Value.(flag.Getter).Get().(string)
+	CmdArgs.ContainerRuntime = flag.Lookup("container-runtime").Value.(flag.Getter).Get().(string)
+	CmdArgs.APIMode = flag.Lookup("api-mode").Value.(flag.Getter).Get().(string)
+	CmdArgs.PolicyFile = flag.Lookup("policy-file").Value.(flag.Getter).Get().(string)
+	CmdArgs.PolicyRegoFile = flag.Lookup("policy-rego-file").Value.(flag.Getter).Get().(string)
+	CmdArgs.MaxRetries = flag.Lookup("max-retries").Value.(flag.Getter).Get().(int)
+	CmdArgs.RetryMaxElapsed = time.Duration(flag.Lookup("retry-max-elapsed").Value.(flag.Getter).Get().(int)) * time.Second
+	CmdArgs.AuditLogPath = flag.Lookup("auditLogPath").Value.(flag.Getter).Get().(string)
+	CmdArgs.PatchStrategy = flag.Lookup("patch-strategy").Value.(flag.Getter).Get().(string)
+	CmdArgs.AgentLeaseMaxAge = time.Duration(flag.Lookup("agent-lease-max-age").Value.(flag.Getter).Get().(int)) * time.Second
+
+	// Every privileged command, successful or not, is auditable beyond a glog line: an Event on
+	// the target pod plus a JSON line written by writeAudit
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+
+	var auditWriter io.Writer
+	if CmdArgs.AuditLogPath != "" {
+		auditFile, err := os.OpenFile(CmdArgs.AuditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			glog.Fatalf("Failed to open audit log %s: %v", CmdArgs.AuditLogPath, err)
+		}
+		auditWriter = auditFile
+	}
 
 	// Initialize privilegeCmdController object
 	privilegeCmdController := &privilegeCmdController{
-		client:     client,
-		restConfig: restConfig,
+		client:          client,
+		restConfig:      restConfig,
+		nodeConcurrency: newNodeConcurrency(),
+		retryQueue:      newRetryQueue(CmdArgs.MaxRetries, CmdArgs.RetryMaxElapsed),
+		eventRecorder:   eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "privilege-cmd-controller"}),
+		auditWriter:     auditWriter,
+		ctx:             ctx,
+	}
+
+	// In daemonset agent mode, reconcile the per-node agent DaemonSet up front so
+	// handleActiveStatus can reuse already-running agent pods instead of creating one per request
+	if CmdArgs.AgentMode == AgentModeDaemonSet {
+		privilegeCmdController.agentPool = agent.NewPool(client, CmdArgs.Namespace, CmdArgs.Image)
+		if err := privilegeCmdController.agentPool.Reconcile(); err != nil {
+			glog.Errorf("Failed to reconcile agent DaemonSet: %v", err)
+		}
+	}
+
+	// Best-effort: mirror annotation-driven requests into PrivilegeCommand objects so CRD-aware
+	// tooling can observe them during the --api-mode=annotations migration window. On a cluster
+	// without the PrivilegeCommand CRD installed yet, the shim's writes fail and are only logged.
+	if crdClient, err := versioned.NewForConfig(restConfig); err != nil {
+		glog.Warningf("Failed to build PrivilegeCommand client for the annotation compatibility shim: %v", err)
+	} else {
+		privilegeCmdController.crdClient = crdClient
+	}
+
+	// Load the configured command policy; with neither flag set, all requests are allowed,
+	// matching the pre-existing behavior. --policy-rego-file takes precedence over
+	// --policy-file when both are set, since Rego can express everything the static allow/deny
+	// list can and more.
+	switch {
+	case CmdArgs.PolicyRegoFile != "":
+		module, err := ioutil.ReadFile(CmdArgs.PolicyRegoFile)
+		if err != nil {
+			glog.Fatalf("Failed to read rego policy file %s: %v", CmdArgs.PolicyRegoFile, err)
+		}
+		evaluator, err := policy.NewRegoEvaluator(ctx, string(module))
+		if err != nil {
+			glog.Fatalf("Failed to compile rego policy file %s: %v", CmdArgs.PolicyRegoFile, err)
+		}
+		privilegeCmdController.policyEvaluator = evaluator
+	case CmdArgs.PolicyFile != "":
+		evaluator, err := policy.LoadStaticEvaluator(CmdArgs.PolicyFile)
+		if err != nil {
+			glog.Fatalf("Failed to load policy file %s: %v", CmdArgs.PolicyFile, err)
+		}
+		privilegeCmdController.policyEvaluator = evaluator
 	}
 
 	// Construct the controller object for privilegeCmdController
@@ -77,52 +197,66 @@ func NewPrivilegeCmdController(client kubernetes.Interface, restConfig *rest.Con
 	return privilegeCmdController
 }
 
-// handleUpdate handles updates to the privileged-command-status annotation
+// recordEvent emits an eventType/reason Event on target via pc.eventRecorder, the audit trail
+// surfaced by `kubectl describe pod`/`kubectl get events` beyond a glog line keyed by reqID. A
+// nil eventRecorder (as in tests that build privilegeCmdController directly) is a no-op.
+func (pc *privilegeCmdController) recordEvent(target *v1.Pod, eventType, reason, message string) {
+	if pc.eventRecorder == nil {
+		return
+	}
+	pc.eventRecorder.Event(target, eventType, reason, message)
+}
+
+// handleUpdate enqueues updates to the privileged-command-status annotation onto the retry
+// queue instead of processing them synchronously, so a transient failure is retried with
+// backoff rather than immediately falling back to StatusError
 func (pc *privilegeCmdController) handleUpdate(oldObj interface{}, newObj interface{}) {
 	oldPodResource := oldObj.(*v1.Pod)
 	newPodResource := newObj.(*v1.Pod)
 
-	// Second constraint ensures that all the annotations exist
+	// Constraint ensures that all the annotations exist
 	if newPodResource.Annotations != nil &&
 		newPodResource.Annotations[constants.AnnotationExecuteContainer] != "" &&
 		newPodResource.Annotations[constants.AnnotationExecuteAction] != "" &&
 		newPodResource.Annotations[constants.AnnotationExecuteStatus] != "" {
-		for podAnnotationKey := range newPodResource.Annotations {
-			if podAnnotationKey == constants.AnnotationExecuteStatus {
-				// Construct the privilege pod name
-				privPodName := fmt.Sprintf("priv_%s_%s", newPodResource.Name, newPodResource.Annotations[constants.AnnotationExecuteContainer])
-				privPodName = strings.Replace(privPodName, "_", "-", -1)
-				privPodName = strings.ToLower(privPodName)
-
-				// Construct request specs for the current request
-				currRequestSpec := requestSpec{
-					privPodName: privPodName,
-					reqID:       guuid.New().String(),
-				}
+		pc.retryQueue.enqueue(retryRequest{
+			oldPod: oldPodResource,
+			newPod: newPodResource,
+			reqID:  guuid.New().String(),
+		})
+	}
+}
+
+// StartRetryWorker drains the retry queue until this controller's ctx is done, processing each
+// enqueued update with a context bounded by --privPodTimeout and retrying failures with backoff
+// up to --max-retries before finally transitioning the request to StatusError
+func (pc *privilegeCmdController) StartRetryWorker() {
+	go pc.retryQueue.run(pc, pc.ctx.Done())
+}
+
+// leaseGCInterval is how often StartLeaseGarbageCollector sweeps agent pods for stale leases
+const leaseGCInterval = time.Minute
 
-				// Set up the handler
-				handler := Process
-				err := handler(pc, oldPodResource, newPodResource, &currRequestSpec)
-
-				// Handle any errors from executing privileged command
-				// Delete privilege pod, update annotation to error and log the error on the controller
-				if err != nil {
-					glog.Errorf("[%s] Error on update request on pod %s: %v", currRequestSpec.reqID, newPodResource.Name, err)
-
-					// Delete privileged pod
-					err = deletePod(pc.client, CmdArgs.Namespace, &currRequestSpec)
-					if err != nil {
-						glog.Errorf("[%s] Failure to delete pod after error: %s", currRequestSpec.reqID, err)
-					}
-
-					// Update privileged-command-status annotation to error
-					// Annotations to be deleted on the plugin side
-					err = updatePrivilegedCommandExecutorAnnotation(pc.client, newPodResource.Namespace, newPodResource, constants.StatusError, &currRequestSpec)
-					if err != nil {
-						glog.Errorf("[%s] Failure to update annotation after error: %s", currRequestSpec.reqID, err)
-					}
+// StartLeaseGarbageCollector periodically clears agent pod leases that have not been refreshed
+// within --agent-lease-max-age, until this controller's ctx is done. It is a no-op in
+// AgentModeOnDemand, where there is no agentPool to sweep.
+func (pc *privilegeCmdController) StartLeaseGarbageCollector() {
+	if pc.agentPool == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(leaseGCInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pc.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := pc.agentPool.GarbageCollectLeases(CmdArgs.AgentLeaseMaxAge); err != nil {
+					glog.Errorf("Failed to garbage collect agent pod leases: %v", err)
 				}
 			}
 		}
-	}
+	}()
 }
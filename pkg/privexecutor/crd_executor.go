@@ -0,0 +1,126 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package privexecutor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	guuid "github.com/google/uuid"
+	privilegev1alpha1 "github.com/yahoo/privilege-cmd-controller/pkg/apis/privilege/v1alpha1"
+	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+	"github.com/yahoo/privilege-cmd-controller/pkg/controller"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// CRDExecutor runs privileged commands against a named pod/container on behalf of
+// pkg/controller's PrivilegeCommand reconciler, reusing the same privileged pod lifecycle and
+// nsenter invocation as the annotation-driven Process path.
+type CRDExecutor struct {
+	client     kubernetes.Interface
+	restConfig *rest.Config
+}
+
+// NewCRDExecutor returns a CRDExecutor backed by client and restConfig
+func NewCRDExecutor(client kubernetes.Interface, restConfig *rest.Config) *CRDExecutor {
+	return &CRDExecutor{client: client, restConfig: restConfig}
+}
+
+// Execute runs command against container in podName, bounded by timeoutSeconds if set (falling
+// back to CmdArgs.PrivPodTimeout). It implements pkg/controller's Executor interface.
+func (e *CRDExecutor) Execute(reqID string, podName string, container string, command []string, timeoutSeconds *int32) (controller.ExecResult, error) {
+	pod, err := e.client.CoreV1().Pods("").Get(podName, metav1.GetOptions{})
+	if err != nil {
+		return controller.ExecResult{ExitCode: -1}, fmt.Errorf("failed to get target pod %s: %s", podName, err)
+	}
+
+	containerID := ""
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container {
+			containerID = status.ContainerID
+		}
+	}
+	if containerID == "" {
+		return controller.ExecResult{ExitCode: -1, Reason: privilegev1alpha1.ReasonTargetContainerNotFound},
+			fmt.Errorf("no matching container ID for container %s on pod %s", container, podName)
+	}
+
+	nodeName, err := getNodeName(context.Background(), e.client, pod)
+	if err != nil {
+		return controller.ExecResult{ExitCode: -1}, fmt.Errorf("failed to detect target node: %s", err)
+	}
+
+	timeout := time.Duration(CmdArgs.PrivPodTimeout) * time.Second
+	if timeoutSeconds != nil {
+		timeout = time.Duration(*timeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	spec := &requestSpec{
+		privPodName: privPodNameFor(pod.Name, container),
+		reqID:       reqIDOrNew(reqID),
+		ctx:         ctx,
+	}
+
+	if err := createPrivilegedPod(e.client, CmdArgs.Namespace, nodeName, spec); err != nil {
+		return controller.ExecResult{ExitCode: -1, PrivPodName: spec.privPodName, Reason: reasonFor(err)}, err
+	}
+	defer func() {
+		if err := deletePod(e.client, CmdArgs.Namespace, spec); err != nil {
+			glog.Errorf("[%s] Failure to delete pod after CRD-driven execution: %s", spec.reqID, err)
+		}
+	}()
+
+	rce := remoteCmdExecutor{
+		client:             e.client,
+		restConfig:         e.restConfig,
+		namespace:          CmdArgs.Namespace,
+		requestSpec:        spec,
+		container:          container,
+		containerID:        containerID,
+		privilegeContainer: constants.PrivilegeContainer,
+		privPodName:        spec.privPodName,
+	}
+
+	output, err := executeNsenterCommand(&rce, command)
+	if err != nil {
+		return controller.ExecResult{Output: output, ExitCode: -1, PrivPodName: spec.privPodName}, err
+	}
+	return controller.ExecResult{Output: output, ExitCode: 0, PrivPodName: spec.privPodName}, nil
+}
+
+// reasonFor maps an error from createPrivilegedPod to one of v1alpha1's Reason* constants, or ""
+// if it doesn't match a known cause
+func reasonFor(err error) string {
+	var failure *privPodFailure
+	if errors.As(err, &failure) {
+		return failure.reason
+	}
+	if strings.Contains(err.Error(), "is not running after") {
+		return privilegev1alpha1.ReasonPrivPodTimeout
+	}
+	return ""
+}
+
+// privPodNameFor names the privilege pod for a target pod and container, for both
+// annotation-driven requests (via the retry queue) and CRD-driven ones
+func privPodNameFor(podName, container string) string {
+	name := fmt.Sprintf("priv_%s_%s", podName, container)
+	name = strings.Replace(name, "_", "-", -1)
+	return strings.ToLower(name)
+}
+
+// reqIDOrNew returns reqID if set, or generates a new one the way handleUpdate does for every update
+func reqIDOrNew(reqID string) string {
+	if reqID != "" {
+		return reqID
+	}
+	return guuid.New().String()
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package privexecutor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	privilegev1alpha1 "github.com/yahoo/privilege-cmd-controller/pkg/apis/privilege/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// shimAnnotationToCRD is the deprecated compatibility path for --api-mode=annotations: once
+// pc.crdClient is configured, it mirrors each annotation-driven request into its own
+// PrivilegeCommand object so tooling built against the typed CRD (see pkg/apis/privilege/v1alpha1
+// and pkg/controller) can observe annotation-driven requests during the migration window. Each
+// request gets a freshly Created object named from its reqID rather than reusing one keyed by
+// pod+container, so a pod that receives many requests keeps one CR per request instead of a
+// single CR whose Spec and Status drift out of sync with each other. It is best-effort and never
+// returns an error: a mirroring failure is logged, not allowed to fail the underlying
+// annotation-driven request.
+func shimAnnotationToCRD(pc *privilegeCmdController, newPodResource *v1.Pod, requestSpec *requestSpec, containerName string, action string, output string, execErr error) {
+	if pc.crdClient == nil {
+		return
+	}
+
+	namespace := newPodResource.Namespace
+	name := shimNameFor(newPodResource.Name, containerName, requestSpec.reqID)
+	commands := pc.crdClient.PrivilegeV1alpha1().PrivilegeCommands(namespace)
+
+	cmd, err := commands.Create(&privilegev1alpha1.PrivilegeCommand{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: privilegev1alpha1.PrivilegeCommandSpec{
+			PodName:   newPodResource.Name,
+			Container: containerName,
+			Command:   strings.Fields(action),
+		},
+	})
+	if err != nil {
+		glog.Warningf("[%s] Failed to shim annotation-driven request into a PrivilegeCommand: %s", requestSpec.reqID, err)
+		return
+	}
+
+	exitCode := int32(0)
+	cmd.Status.Phase = privilegev1alpha1.PhaseSucceeded
+	if execErr != nil {
+		exitCode = -1
+		cmd.Status.Phase = privilegev1alpha1.PhaseFailed
+	}
+	cmd.Status.ReqID = requestSpec.reqID
+	cmd.Status.Output = output
+	cmd.Status.ExitCode = &exitCode
+	now := metav1.Now()
+	cmd.Status.CompletionTime = &now
+
+	if _, err := commands.UpdateStatus(cmd); err != nil {
+		glog.Warningf("[%s] Failed to update shimmed PrivilegeCommand %s status: %s", requestSpec.reqID, name, err)
+	}
+}
+
+// shimNameFor names the per-request shimmed PrivilegeCommand, keyed by reqID rather than by
+// pod+container so distinct requests against the same pod and container each get their own object
+func shimNameFor(podName, container, reqID string) string {
+	name := fmt.Sprintf("priv-%s-%s-%s", podName, container, reqID)
+	return strings.ToLower(name)
+}
@@ -3,6 +3,7 @@
 package privexecutor
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+	"github.com/yahoo/privilege-cmd-controller/pkg/policy"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -17,6 +19,14 @@ import (
 type requestSpec struct {
 	privPodName string
 	reqID       string
+	// requester is the privileged-command-requester identity this request was made under, if
+	// known; StreamCommandOnPod records it alongside the reqID's ring buffer so the stream
+	// server can require a client to present a matching identity before it tails the output.
+	// Empty for requests the policy engine cannot attribute to a requester (e.g. CRD-driven ones).
+	requester string
+	// ctx bounds how long this request's remote command execution may run; StreamCommandOnPod
+	// cancels a hung SPDY stream once ctx is done. A nil ctx is treated as context.Background.
+	ctx context.Context
 }
 
 // Process handles changes in annotations and makes actions corresponding to the current status
@@ -26,14 +36,14 @@ func Process(pc *privilegeCmdController, oldPodResource *v1.Pod, newPodResource
 	case newPodResource.Annotations[constants.AnnotationExecuteStatus] == constants.StatusActive:
 		err := handleActiveStatus(pc, oldPodResource, newPodResource, requestSpec)
 		if err != nil {
-			return fmt.Errorf("unable to act upon annotation %s change to %s: %s", constants.AnnotationExecuteStatus, constants.StatusActive, err)
+			return fmt.Errorf("unable to act upon annotation %s change to %s: %w", constants.AnnotationExecuteStatus, constants.StatusActive, err)
 		}
 		return nil
 	// privileged-command-status annotation is done
 	case newPodResource.Annotations[constants.AnnotationExecuteStatus] == constants.StatusDone:
 		err := handleDoneStatus(pc, oldPodResource, newPodResource, requestSpec)
 		if err != nil {
-			return fmt.Errorf("unable to act upon annotation %s change to %s: %s", constants.AnnotationExecuteStatus, constants.StatusDone, err)
+			return fmt.Errorf("unable to act upon annotation %s change to %s: %w", constants.AnnotationExecuteStatus, constants.StatusDone, err)
 		}
 		return nil
 	}
@@ -41,7 +51,43 @@ func Process(pc *privilegeCmdController, oldPodResource *v1.Pod, newPodResource
 }
 
 // handleActiveStatus takes necessary actions when privileged-command-status annotation switches to "active"
-func handleActiveStatus(pc *privilegeCmdController, oldPodResource *v1.Pod, newPodResource *v1.Pod, requestSpec *requestSpec) error {
+func handleActiveStatus(pc *privilegeCmdController, oldPodResource *v1.Pod, newPodResource *v1.Pod, requestSpec *requestSpec) (err error) {
+	requester := newPodResource.Annotations[constants.AnnotationExecuteRequester]
+	containerName := newPodResource.Annotations[constants.AnnotationExecuteContainer]
+	action := newPodResource.Annotations[constants.AnnotationExecuteAction]
+	var nodeName, output string
+
+	pc.recordEvent(newPodResource, v1.EventTypeNormal, "PrivCommandRequested",
+		fmt.Sprintf("requester=%s container=%s action=%q", requester, containerName, action))
+
+	// Every attempt, successful or not, gets a PrivCommandCompleted/PrivCommandFailed Event and a
+	// JSON audit line, regardless of which of the returns below it takes
+	start := time.Now()
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+			pc.recordEvent(newPodResource, v1.EventTypeWarning, "PrivCommandFailed",
+				fmt.Sprintf("requester=%s container=%s action=%q duration=%s: %s", requester, containerName, action, time.Since(start), err))
+		} else {
+			pc.recordEvent(newPodResource, v1.EventTypeNormal, "PrivCommandCompleted",
+				fmt.Sprintf("requester=%s container=%s action=%q duration=%s", requester, containerName, action, time.Since(start)))
+		}
+		shimAnnotationToCRD(pc, newPodResource, requestSpec, containerName, action, output, err)
+		pc.writeAudit(auditRecord{
+			ReqID:           requestSpec.reqID,
+			Timestamp:       start,
+			Requester:       requester,
+			TargetPod:       newPodResource.Name,
+			TargetContainer: containerName,
+			Node:            nodeName,
+			Action:          action,
+			Args:            strings.Fields(action),
+			Outcome:         outcome,
+			DurationMs:      time.Since(start).Milliseconds(),
+		})
+	}()
+
 	// Retry action if it failed previously.
 	if oldPodResource != nil && oldPodResource.Annotations != nil &&
 		oldPodResource.Annotations[constants.AnnotationExecuteStatus] == constants.StatusActive {
@@ -49,36 +95,73 @@ func handleActiveStatus(pc *privilegeCmdController, oldPodResource *v1.Pod, newP
 	}
 
 	// Update privileged-command-status annotation to in-progress
-	err := updatePrivilegedCommandExecutorAnnotation(pc.client, newPodResource.Namespace, newPodResource, constants.StatusInProgress, requestSpec)
-	if err != nil {
+	if err = updatePrivilegedCommandExecutorAnnotation(pc.client, newPodResource.Namespace, newPodResource, constants.StatusInProgress, requestSpec); err != nil {
 		return fmt.Errorf("failed to update %s annotation to %s: %s", constants.AnnotationExecuteStatus, constants.StatusInProgress, err)
 	}
 
-	// Detect container ID of target container
+	// Detect container ID of target container. This is kept in its full <scheme>://<id> form
+	// (e.g. docker://, containerd://, cri-o://) so getPID can dispatch to the right pkg/runtime
+	// implementation instead of assuming Docker.
 	containerID := ""
 	for _, p := range newPodResource.Status.ContainerStatuses {
-		if p.Name == newPodResource.Annotations[constants.AnnotationExecuteContainer] {
-			// container ID is in the form docker://<container_id>
-			// so we trim the prefix docker://
-			containerID = p.ContainerID[9:]
-			glog.Infof("[%s] Container ID for container %s on pod %s under namespace %s: %s", requestSpec.reqID, newPodResource.Annotations[constants.AnnotationExecuteContainer], newPodResource.Name, newPodResource.Namespace, containerID)
+		if p.Name == containerName {
+			containerID = p.ContainerID
+			glog.Infof("[%s] Container ID for container %s on pod %s under namespace %s: %s", requestSpec.reqID, containerName, newPodResource.Name, newPodResource.Namespace, containerID)
 		}
 	}
 	if containerID == "" {
-		return fmt.Errorf("no matching container ID for container %s on pod %s under namespace %s", newPodResource.Annotations[constants.AnnotationExecuteContainer], newPodResource.Name, newPodResource.Namespace)
+		return fmt.Errorf("no matching container ID for container %s on pod %s under namespace %s", containerName, newPodResource.Name, newPodResource.Namespace)
+	}
+
+	// Consult command policy before ever creating a privileged pod. A denial is recorded on
+	// the target pod as an Event and fails the request without touching the node.
+	if err = checkPolicy(pc, newPodResource, containerID, requestSpec); err != nil {
+		return err
 	}
 
 	// Detect node name of the target pod
-	nodeName, err := getNodeName(newPodResource)
+	ctx := requestSpec.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	nodeName, err = getNodeName(ctx, pc.client, newPodResource)
 	if err != nil {
 		return errors.New("failed to detect target node: %s" + err.Error())
 	}
-	glog.Infof("[%s] Target node for container %s in pod %s is %s", requestSpec.reqID, newPodResource.Annotations[constants.AnnotationExecuteContainer], newPodResource.Name, nodeName)
+	glog.Infof("[%s] Target node for container %s in pod %s is %s", requestSpec.reqID, containerName, newPodResource.Name, nodeName)
+
+	// Enforce the policy's per-node concurrency cap, if any. The slot is held for the
+	// remainder of this synchronous call, which covers creating the privileged pod and running
+	// the command; the slot is released before handleDoneStatus deletes the pod.
+	if limiter, ok := pc.policyEvaluator.(policy.NodeLimiter); ok {
+		if max := limiter.MaxConcurrentPerNode(); max > 0 {
+			if !pc.nodeConcurrency.acquire(nodeName, max) {
+				return denyRequest(pc, newPodResource, requestSpec, fmt.Sprintf("max concurrent privileged commands (%d) already running on node %s", max, nodeName))
+			}
+			defer pc.nodeConcurrency.release(nodeName)
+		}
+	}
 
-	// Create privileged pod
-	err = createPrivilegedPod(pc.client, CmdArgs.Namespace, nodeName, requestSpec)
-	if err != nil {
-		return err
+	// Acquire the privilege pod to execute against: in daemonset agent mode, reuse the
+	// already-running agent pod on the target node; otherwise create a fresh pod per request
+	privPodName := requestSpec.privPodName
+	if CmdArgs.AgentMode == AgentModeDaemonSet {
+		if pc.agentPool == nil {
+			return errors.New("agent mode is daemonset but no agent pool is configured")
+		}
+		agentPod, err := pc.agentPool.AgentForNode(nodeName)
+		if err != nil {
+			return fmt.Errorf("failed to locate agent pod on node %s: %s", nodeName, err)
+		}
+		privPodName = agentPod.Name
+	} else {
+		pc.recordEvent(newPodResource, v1.EventTypeNormal, "PrivPodCreated",
+			fmt.Sprintf("creating privileged pod %s on node %s for requester=%s", privPodName, nodeName, requester))
+		if err = createPrivilegedPod(pc.client, CmdArgs.Namespace, nodeName, requestSpec); err != nil {
+			return err
+		}
+		pc.recordEvent(newPodResource, v1.EventTypeNormal, "PrivPodRunning",
+			fmt.Sprintf("privileged pod %s is running on node %s", privPodName, nodeName))
 	}
 
 	// Set up remoteCmdExecutor object to execute remote command in the privileged pod
@@ -87,23 +170,22 @@ func handleActiveStatus(pc *privilegeCmdController, oldPodResource *v1.Pod, newP
 		restConfig:         pc.restConfig,
 		namespace:          CmdArgs.Namespace,
 		requestSpec:        requestSpec,
-		container:          newPodResource.Annotations[constants.AnnotationExecuteContainer],
+		container:          containerName,
 		containerID:        containerID,
 		privilegeContainer: constants.PrivilegeContainer,
+		privPodName:        privPodName,
 	}
 
 	// Execute specified command supplied by annotations
-	action := newPodResource.Annotations[constants.AnnotationExecuteAction]
 	actionToExec := strings.Fields(action)
-	output, err := executeNsenterCommand(&rce, actionToExec)
+	output, err = executeNsenterCommand(&rce, actionToExec)
 	if err != nil {
 		return errors.New("failed to execute command: " + err.Error())
 	}
 	glog.Infof("[%s] \n%v", requestSpec.reqID, output)
 
 	// Update privileged-command-status annotation to done
-	err = updatePrivilegedCommandExecutorAnnotation(pc.client, newPodResource.Namespace, newPodResource, constants.StatusDone, requestSpec)
-	if err != nil {
+	if err = updatePrivilegedCommandExecutorAnnotation(pc.client, newPodResource.Namespace, newPodResource, constants.StatusDone, requestSpec); err != nil {
 		return fmt.Errorf("failed to update %s annotation to %s: %s", constants.AnnotationExecuteStatus, constants.StatusDone, err)
 	}
 	return nil
@@ -114,15 +196,18 @@ func handleDoneStatus(pc *privilegeCmdController, oldPodResource *v1.Pod, newPod
 	// Sleep to sync with the plugin
 	time.Sleep(time.Second)
 
-	// Delete the privileged pod
-	glog.Infof("[%s] Deleting privilege pod", requestSpec.reqID)
-	err := deletePod(pc.client, CmdArgs.Namespace, requestSpec)
-	if err != nil {
-		return err
+	// In daemonset agent mode the agent pod is long-lived and shared across requests, so it
+	// is never deleted here; only the on-demand privilege pod is torn down per request
+	if CmdArgs.AgentMode != AgentModeDaemonSet {
+		glog.Infof("[%s] Deleting privilege pod", requestSpec.reqID)
+		err := deletePod(pc.client, CmdArgs.Namespace, requestSpec)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Delete privileged-command-status and privileged-command-container Annotation
-	err = deletePrivilegedCommandExecutorAnnotation(pc.client, newPodResource.Namespace, newPodResource, requestSpec)
+	err := deletePrivilegedCommandExecutorAnnotation(pc.client, newPodResource.Namespace, newPodResource, requestSpec)
 	if err != nil {
 		return fmt.Errorf("failed to delete annotations %s, %s and %s: %s", constants.AnnotationExecuteStatus, constants.AnnotationExecuteContainer, constants.AnnotationExecuteAction, err)
 	}
@@ -6,9 +6,11 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+	"github.com/yahoo/privilege-cmd-controller/pkg/policy"
 
 	guuid "github.com/google/uuid"
 
@@ -16,8 +18,32 @@ import (
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
+// expectEvents drains recorder's buffered Events and asserts their reasons are exactly
+// wantReasons, in order (each buffered event is "<type> <reason> <message>")
+func expectEvents(t *testing.T, recorder *record.FakeRecorder, wantReasons ...string) {
+	t.Helper()
+	close(recorder.Events)
+	var gotReasons []string
+	for event := range recorder.Events {
+		fields := strings.SplitN(event, " ", 3)
+		if len(fields) < 2 {
+			t.Fatalf("malformed recorded event: %q", event)
+		}
+		gotReasons = append(gotReasons, fields[1])
+	}
+	if len(gotReasons) != len(wantReasons) {
+		t.Fatalf("expected events %v; got %v", wantReasons, gotReasons)
+	}
+	for i, want := range wantReasons {
+		if gotReasons[i] != want {
+			t.Errorf("expected event %d to be %s; got %s (all events: %v)", i, want, gotReasons[i], gotReasons)
+		}
+	}
+}
+
 // init initializes the flag variables for all tests
 func init() {
 	var _ = flag.String("privilegePodImage", "docker.ouroath.com:4443/yahoo-cloud/priv-cmd-exec-util:latest", "Image for the privileged pod to be created on target node. It contains all related privileged command utilities.")
@@ -77,8 +103,18 @@ func TestProcess(t *testing.T) {
 		reqID:       guuid.New().String(),
 	}
 	client := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+	// Annotations are patched against the target pod as stored, and the container runtime is
+	// detected from its node, so both must exist in the client
+	if _, err := client.CoreV1().Pods("default").Create(targetPodSpecWithNode); err != nil {
+		t.Fatalf("Failed to create target pod on fake client: %s", err)
+	}
+	if err := createNode(client, "targetNode", "docker://19.3.0"); err != nil {
+		t.Fatalf("Failed to create node targetNode on fake client: %s", err)
+	}
 	privilegeCmdController := &privilegeCmdController{
-		client: client,
+		client:        client,
+		eventRecorder: recorder,
 	}
 	namespace := "default"
 	newPod := targetPodSpecWithNode
@@ -90,6 +126,7 @@ func TestProcess(t *testing.T) {
 			constants.AnnotationExecuteStatus:    constants.StatusActive,
 			constants.AnnotationExecuteContainer: "target-container",
 			constants.AnnotationExecuteAction:    "target-action",
+			constants.AnnotationExecuteRequester: "test-user",
 		},
 		newPod,
 		&currRequest,
@@ -102,6 +139,7 @@ func TestProcess(t *testing.T) {
 	if err.Error() != expectedError.Error() {
 		t.Errorf("Expected error: %s ; Actual error: %s", expectedError, err)
 	}
+	expectEvents(t, recorder, "PrivCommandRequested", "PrivPodCreated", "PrivCommandFailed")
 
 	// Retrieve pod from client and ensure that it has been created in the correct node with the correct name
 	pod, err := client.CoreV1().Pods(namespace).Get("priv-test-pod-target-container", metav1.GetOptions{})
@@ -154,8 +192,18 @@ func TestHandleActiveStatus(t *testing.T) {
 
 	// Create fake client
 	client := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+	// Annotations are patched against the target pod as stored, and the container runtime is
+	// detected from its node, so both must exist in the client
+	if _, err := client.CoreV1().Pods("default").Create(targetPodSpecWithNode); err != nil {
+		t.Fatalf("Failed to create target pod on fake client: %s", err)
+	}
+	if err := createNode(client, "targetNode", "docker://19.3.0"); err != nil {
+		t.Fatalf("Failed to create node targetNode on fake client: %s", err)
+	}
 	privilegeCmdController := &privilegeCmdController{
-		client: client,
+		client:        client,
+		eventRecorder: recorder,
 	}
 	namespace := "default"
 	newPod := targetPodSpecWithNode
@@ -167,6 +215,7 @@ func TestHandleActiveStatus(t *testing.T) {
 		map[string]string{
 			constants.AnnotationExecuteStatus:    constants.StatusActive,
 			constants.AnnotationExecuteContainer: "target-container",
+			constants.AnnotationExecuteRequester: "test-user",
 		},
 		newPod,
 		&currRequest,
@@ -178,6 +227,7 @@ func TestHandleActiveStatus(t *testing.T) {
 	if err.Error() != expectedError.Error() {
 		t.Errorf("Expected error: %s ; Actual error: %s", expectedError, err)
 	}
+	expectEvents(t, recorder, "PrivCommandRequested", "PrivPodCreated", "PrivCommandFailed")
 
 	// Target pod should have its annotation changed to in-progress
 	if newPod.Annotations[constants.AnnotationExecuteStatus] != constants.StatusInProgress {
@@ -214,8 +264,14 @@ func TestHandleDoneStatus(t *testing.T) {
 
 	// Create fake client
 	client := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+	// Annotations are patched against the target pod as stored, so it must exist in the client
+	if _, err := client.CoreV1().Pods("default").Create(targetPodSpecWithNode); err != nil {
+		t.Fatalf("Failed to create target pod on fake client: %s", err)
+	}
 	privilegeCmdController := &privilegeCmdController{
-		client: client,
+		client:        client,
+		eventRecorder: recorder,
 	}
 	namespace := "default"
 	newPod := targetPodSpecWithNode
@@ -247,6 +303,7 @@ func TestHandleDoneStatus(t *testing.T) {
 			constants.AnnotationExecuteStatus:    constants.StatusDone,
 			constants.AnnotationExecuteContainer: "target-container",
 			constants.AnnotationExecuteAction:    "target-action",
+			constants.AnnotationExecuteRequester: "test-user",
 		},
 		newPod,
 		&currRequest,
@@ -257,6 +314,9 @@ func TestHandleDoneStatus(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error handling done status: %s", err)
 	}
+	// handleDoneStatus only tears down the privilege pod and annotations; the PrivCommand*
+	// lifecycle Events are all recorded by handleActiveStatus, which already ran for this request
+	expectEvents(t, recorder)
 
 	// Check that the privilege pod no longer exists
 	_, err = client.CoreV1().Pods("").Get("priv-test-pod-target-container", metav1.GetOptions{})
@@ -269,3 +329,138 @@ func TestHandleDoneStatus(t *testing.T) {
 		t.Errorf("Annotations %s or %s have not been deleted", constants.AnnotationExecuteContainer, constants.AnnotationExecuteStatus)
 	}
 }
+
+// denyAllEvaluator denies every request, used to test that policy denial short-circuits handleActiveStatus
+type denyAllEvaluator struct{}
+
+func (denyAllEvaluator) Evaluate(input policy.Input) (policy.Decision, error) {
+	return policy.Decision{Allowed: false, Reason: "denied for test"}, nil
+}
+
+// TestHandleActiveStatusPolicyDenied checks that a policy denial prevents privileged pod creation
+func TestHandleActiveStatusPolicyDenied(t *testing.T) {
+	CmdArgs.PrivPodTimeout = 3
+	CmdArgs.Image = "image"
+	CmdArgs.Namespace = "default"
+
+	currRequest := requestSpec{
+		privPodName: "priv-test-pod-target-container",
+		reqID:       guuid.New().String(),
+	}
+
+	client := fake.NewSimpleClientset()
+	privilegeCmdController := &privilegeCmdController{
+		client:          client,
+		policyEvaluator: denyAllEvaluator{},
+	}
+	namespace := "default"
+	newPod := targetPodSpecWithNode.DeepCopy()
+	oldPod := newPod.DeepCopy()
+	if _, err := client.CoreV1().Pods(namespace).Create(newPod); err != nil {
+		t.Fatalf("Failed to create target pod on fake client: %s", err)
+	}
+
+	_ = applyAnnotationUpdateOnPod(client,
+		namespace,
+		map[string]string{
+			constants.AnnotationExecuteStatus:    constants.StatusActive,
+			constants.AnnotationExecuteContainer: "target-container",
+			constants.AnnotationExecuteAction:    "target-action",
+			constants.AnnotationExecuteRequester: "test-user",
+		},
+		newPod,
+		&currRequest,
+	)
+
+	err := handleActiveStatus(privilegeCmdController, oldPod, newPod, &currRequest)
+	if err == nil {
+		t.Fatal("expected an error from a policy-denied request")
+	}
+
+	if _, getErr := client.CoreV1().Pods(namespace).Get(currRequest.privPodName, metav1.GetOptions{}); !k8serrors.IsNotFound(getErr) {
+		t.Error("privileged pod should not have been created for a policy-denied request")
+	}
+}
+
+// nodeLimitEvaluator allows every request but caps concurrent requests per node at max
+type nodeLimitEvaluator struct {
+	max int
+}
+
+func (nodeLimitEvaluator) Evaluate(input policy.Input) (policy.Decision, error) {
+	return policy.Decision{Allowed: true}, nil
+}
+
+func (e nodeLimitEvaluator) MaxConcurrentPerNode() int {
+	return e.max
+}
+
+// TestHandleActiveStatusNodeConcurrencyLimitDenied checks that a node already at its policy's
+// MaxConcurrentPerNode cap rejects a further request without creating a privileged pod
+func TestHandleActiveStatusNodeConcurrencyLimitDenied(t *testing.T) {
+	CmdArgs.PrivPodTimeout = 3
+	CmdArgs.Image = "image"
+	CmdArgs.Namespace = "default"
+
+	currRequest := requestSpec{
+		privPodName: "priv-test-pod-target-container",
+		reqID:       guuid.New().String(),
+	}
+
+	client := fake.NewSimpleClientset()
+	privilegeCmdController := &privilegeCmdController{
+		client:          client,
+		policyEvaluator: nodeLimitEvaluator{max: 1},
+		nodeConcurrency: newNodeConcurrency(),
+	}
+	namespace := "default"
+	newPod := targetPodSpecWithNode.DeepCopy()
+	oldPod := newPod.DeepCopy()
+	if _, err := client.CoreV1().Pods(namespace).Create(newPod); err != nil {
+		t.Fatalf("Failed to create target pod on fake client: %s", err)
+	}
+
+	// Occupy the only slot for targetNode before handleActiveStatus ever runs
+	if !privilegeCmdController.nodeConcurrency.acquire("targetNode", 1) {
+		t.Fatal("failed to reserve the test's node concurrency slot")
+	}
+
+	_ = applyAnnotationUpdateOnPod(client,
+		namespace,
+		map[string]string{
+			constants.AnnotationExecuteStatus:    constants.StatusActive,
+			constants.AnnotationExecuteContainer: "target-container",
+			constants.AnnotationExecuteAction:    "target-action",
+			constants.AnnotationExecuteRequester: "test-user",
+		},
+		newPod,
+		&currRequest,
+	)
+
+	err := handleActiveStatus(privilegeCmdController, oldPod, newPod, &currRequest)
+	if err == nil {
+		t.Fatal("expected an error once the node's concurrency cap was reached")
+	}
+
+	if _, getErr := client.CoreV1().Pods(namespace).Get(currRequest.privPodName, metav1.GetOptions{}); !k8serrors.IsNotFound(getErr) {
+		t.Error("privileged pod should not have been created once the node's concurrency cap was reached")
+	}
+}
+
+// TestCheckPolicyNoEvaluatorAllowsMissingRequester checks that without a configured
+// policyEvaluator (e.g. --api-mode=annotations with no --policy-file), requests predating the
+// privileged-command-requester annotation are not denied
+func TestCheckPolicyNoEvaluatorAllowsMissingRequester(t *testing.T) {
+	currRequest := requestSpec{reqID: guuid.New().String()}
+	client := fake.NewSimpleClientset()
+	privilegeCmdController := &privilegeCmdController{client: client}
+	newPod := targetPodSpecWithNode.DeepCopy()
+	newPod.Annotations = map[string]string{
+		constants.AnnotationExecuteContainer: "target-container",
+		constants.AnnotationExecuteAction:    "target-action",
+	}
+
+	if err := checkPolicy(privilegeCmdController, newPod, "container-id", &currRequest); err != nil {
+		t.Errorf("expected no error with no policyEvaluator configured, got: %s", err)
+	}
+}
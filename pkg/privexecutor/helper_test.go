@@ -36,30 +36,6 @@ func getPodSpec(nodeName string) *v1.Pod {
 	}
 }
 
-// TestGetNodeName tests that function getNodeName will return the correct node names
-// given a pod object
-func TestGetNodeName(t *testing.T) {
-	nodeNames := []string{"node1", "node2"}
-	var podList []*v1.Pod
-
-	// Create pods with different node names and inject into pod list
-	for _, nodeName := range nodeNames {
-		podList = append(podList, getPodSpec(nodeName))
-	}
-
-	// Construct the test node list which will grab all the node names
-	var testNodeList []string
-	for _, pod := range podList {
-		node, _ := getNodeName(pod)
-		testNodeList = append(testNodeList, node)
-	}
-
-	// Test that expected and actual arrays are the same
-	if !reflect.DeepEqual(testNodeList, nodeNames) {
-		t.Errorf("Expected and actual arrays are different. Actual node names: %v. Expected node names: %v", testNodeList, nodeNames)
-	}
-}
-
 // TestApplyAnnotationChangesOnPod tests that function ApplyAnnotationChangesOnPod will
 // correctly update annotations
 func TestApplyAnnotationUpdateOnPod(t *testing.T) {
@@ -72,12 +48,15 @@ func TestApplyAnnotationUpdateOnPod(t *testing.T) {
 		constants.AnnotationExecuteStatus:    "active",
 	}
 
-	// Create expected annotations map
+	// Create expected annotations map. applyAnnotationUpdateOnPod also stashes the serialized
+	// set of annotations it just applied under constants.AnnotationLastApplied, so a later call
+	// can three-way merge against it (see TestApplyAnnotationDeletionOnPod).
 	expectedAnnotations := map[string]string{
 		"init-annotation1":                   "init-value1",
 		"init-annotation2":                   "init-value2",
 		constants.AnnotationExecuteContainer: "targetContainer",
 		constants.AnnotationExecuteStatus:    "active",
+		constants.AnnotationLastApplied:      `{"privileged-command-container":"targetContainer","privileged-command-status":"active"}`,
 	}
 
 	currRequest := requestSpec{
@@ -117,6 +96,7 @@ func TestApplyAnnotationUpdateOnPod(t *testing.T) {
 		"init-annotation2":                   "init-value2",
 		constants.AnnotationExecuteContainer: "targetContainer",
 		constants.AnnotationExecuteStatus:    "in-progress",
+		constants.AnnotationLastApplied:      `{"privileged-command-container":"targetContainer","privileged-command-status":"in-progress"}`,
 	}
 
 	// Update annotation to the pod
@@ -162,3 +142,31 @@ func TestApplyAnnotationDeletionOnPod(t *testing.T) {
 		t.Errorf("Actual annotations: %v ; Expected Annotations: %v", pod.Annotations, expectedAnnotations)
 	}
 }
+
+// TestApplyAnnotationDeletionOnPodMissingAnnotationIsNoOp tests that deleting an annotation that
+// is already absent from the pod is a no-op rather than an error, so a retry of a deletion that
+// already landed does not spuriously fail
+func TestApplyAnnotationDeletionOnPodMissingAnnotationIsNoOp(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	namespace := "default"
+
+	currRequest := requestSpec{
+		privPodName: "priv-test-pod-targetContainer",
+		reqID:       guuid.New().String(),
+	}
+
+	pod, _ := client.CoreV1().Pods(namespace).Create(getPodSpec("targetNode"))
+
+	expectedAnnotations := map[string]string{
+		"init-annotation1": "init-value1",
+		"init-annotation2": "init-value2",
+	}
+
+	err := applyAnnotationDeletionOnPod(client, namespace, []string{"does-not-exist"}, pod, &currRequest)
+	if err != nil {
+		t.Errorf("Expected deleting an already-absent annotation to be a no-op, got error: %s", err)
+	}
+	if !reflect.DeepEqual(pod.Annotations, expectedAnnotations) {
+		t.Errorf("Actual annotations: %v ; Expected Annotations: %v", pod.Annotations, expectedAnnotations)
+	}
+}
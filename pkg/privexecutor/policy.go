@@ -0,0 +1,131 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package privexecutor
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+	"github.com/yahoo/privilege-cmd-controller/pkg/policy"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// nodeConcurrency tracks how many privileged commands are currently executing on each node, so
+// an Evaluator implementing policy.NodeLimiter can be enforced without threading state through
+// policy.Evaluate itself. A nil *nodeConcurrency allows everything, matching a controller
+// constructed directly by tests without NewPrivilegeCmdController.
+type nodeConcurrency struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// newNodeConcurrency returns an empty nodeConcurrency
+func newNodeConcurrency() *nodeConcurrency {
+	return &nodeConcurrency{active: map[string]int{}}
+}
+
+// acquire reserves a slot for node if fewer than max requests are currently running there
+func (nc *nodeConcurrency) acquire(node string, max int) bool {
+	if nc == nil {
+		return true
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.active[node] >= max {
+		return false
+	}
+	nc.active[node]++
+	return true
+}
+
+// release frees the slot reserved by a prior successful acquire for node
+func (nc *nodeConcurrency) release(node string) {
+	if nc == nil {
+		return
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.active[node]--
+}
+
+// checkPolicy evaluates the requested action against pc.policyEvaluator. With no evaluator
+// configured (no --policy-file), it is a no-op, preserving pre-existing annotation-driven
+// requests that predate the privileged-command-requester annotation. Once an evaluator is
+// configured, it requires that annotation to be set, and on any denial records a
+// PrivilegedCommandDenied Event on the target pod.
+func checkPolicy(pc *privilegeCmdController, newPodResource *v1.Pod, containerID string, requestSpec *requestSpec) error {
+	if pc.policyEvaluator == nil {
+		return nil
+	}
+
+	requester := newPodResource.Annotations[constants.AnnotationExecuteRequester]
+	if requester == "" {
+		return denyRequest(pc, newPodResource, requestSpec, fmt.Sprintf("missing required annotation %s", constants.AnnotationExecuteRequester))
+	}
+
+	containerName := newPodResource.Annotations[constants.AnnotationExecuteContainer]
+	image := ""
+	for _, c := range newPodResource.Spec.Containers {
+		if c.Name == containerName {
+			image = c.Image
+		}
+	}
+
+	decision, err := pc.policyEvaluator.Evaluate(policy.Input{
+		Namespace:   newPodResource.Namespace,
+		Image:       image,
+		Pod:         newPodResource.Name,
+		Container:   containerName,
+		ContainerID: containerID,
+		Action:      newPodResource.Annotations[constants.AnnotationExecuteAction],
+		User:        requester,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to evaluate command policy: %s", err)
+	}
+	if !decision.Allowed {
+		return denyRequest(pc, newPodResource, requestSpec, decision.Reason)
+	}
+	return nil
+}
+
+// policyDeniedError marks an error as a permanent policy denial, so the retry queue can fail the
+// request straight to StatusError instead of retrying a decision that will never change
+type policyDeniedError struct {
+	reason string
+}
+
+func (e *policyDeniedError) Error() string {
+	return fmt.Sprintf("privileged command denied by policy: %s", e.reason)
+}
+
+// denyRequest records a PrivilegedCommandDenied Event on the target pod and returns the denial as
+// a *policyDeniedError
+func denyRequest(pc *privilegeCmdController, newPodResource *v1.Pod, requestSpec *requestSpec, reason string) error {
+	glog.Warningf("[%s] Denying privileged command on pod %s: %s", requestSpec.reqID, newPodResource.Name, reason)
+
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "privileged-command-denied-",
+			Namespace:    newPodResource.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:      "Pod",
+			Name:      newPodResource.Name,
+			Namespace: newPodResource.Namespace,
+			UID:       newPodResource.UID,
+		},
+		Reason:  "PrivilegedCommandDenied",
+		Message: reason,
+		Type:    v1.EventTypeWarning,
+		Source:  v1.EventSource{Component: "privilege-cmd-controller"},
+	}
+	if _, err := pc.client.CoreV1().Events(newPodResource.Namespace).Create(event); err != nil {
+		glog.Errorf("[%s] Failed to record PrivilegedCommandDenied event on pod %s: %s", requestSpec.reqID, newPodResource.Name, err)
+	}
+
+	return &policyDeniedError{reason: reason}
+}
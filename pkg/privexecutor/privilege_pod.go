@@ -3,58 +3,168 @@
 package privexecutor
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/golang/glog"
+	privilegev1alpha1 "github.com/yahoo/privilege-cmd-controller/pkg/apis/privilege/v1alpha1"
 	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
-	"k8s.io/apimachinery/pkg/fields"
+	podruntime "github.com/yahoo/privilege-cmd-controller/pkg/privexecutor/runtime"
+	"github.com/yahoo/privilege-cmd-controller/pkg/runtime"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/client-go/util/retry"
 )
 
+// privPodFailure marks an error as a named, unrecoverable reason the privileged pod did not come
+// up (one of v1alpha1's Reason* identifiers), so callers such as CRDExecutor can report it on
+// Status.Conditions instead of a bare error string
+type privPodFailure struct {
+	reason  string
+	message string
+}
+
+func (e *privPodFailure) Error() string {
+	return e.message
+}
+
+// classifyPodFailure inspects pod's container statuses and termination reason for a handful of
+// known, unrecoverable causes. It returns ("", "") if pod has not (yet) failed in a way we can
+// name, in which case the caller should keep waiting.
+func classifyPodFailure(pod *v1.Pod) (reason string, message string) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		if status.State.Waiting.Reason == "ImagePullBackOff" || status.State.Waiting.Reason == "ErrImagePull" {
+			return privilegev1alpha1.ReasonPrivPodImagePullFailed, status.State.Waiting.Message
+		}
+	}
+	switch pod.Status.Reason {
+	case "Evicted":
+		return privilegev1alpha1.ReasonEvicted, pod.Status.Message
+	case "NodeLost":
+		return privilegev1alpha1.ReasonNodeNotReady, pod.Status.Message
+	}
+	return "", ""
+}
+
+// isRetriableWatchError reports whether err establishing or reading a pod watch is a transient
+// API server condition (e.g. a rolling apiserver restart or a rate limit) worth retrying, as
+// opposed to a context deadline or a privPodFailure, neither of which will resolve by retrying
+func isRetriableWatchError(err error) bool {
+	return apierrors.IsUnauthorized(err) || apierrors.IsServerTimeout(err) ||
+		apierrors.IsTooManyRequests(err) || apierrors.IsInternalError(err)
+}
+
+// isRetriableDeleteError reports whether err from deleting the privilege pod is a transient 5xx
+// worth retrying, so a blip in the API server does not permanently leak a privileged pod
+func isRetriableDeleteError(err error) bool {
+	return apierrors.IsServerTimeout(err) || apierrors.IsInternalError(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsTooManyRequests(err)
+}
+
 // createPrivilegedPod creates a new privileged kubernetes pod on target node
 func createPrivilegedPod(client kubernetes.Interface, namespace string, nodeName string, requestSpec *requestSpec) error {
-	glog.Infof("[%s] Creating privileged pod %s in node %s under namespace %s", requestSpec.reqID, requestSpec.privPodName, nodeName, namespace)
+	// requestSpec.ctx is cancelled when the controller loses leadership. Checking it up front
+	// means a replica that has just lost the lease will not create a privileged pod it can no
+	// longer supervise; checking it again in the watch loop below stops one already in flight.
+	ctx := requestSpec.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("not creating privileged pod %s: %s", requestSpec.privPodName, ctx.Err())
+	default:
+	}
+
+	// Resolve which container runtime's socket the privilege pod needs mounted. With
+	// --container-runtime unset or "auto", detect it from the target node's reported runtime
+	// version instead of assuming Docker.
+	containerRuntime := CmdArgs.ContainerRuntime
+	if containerRuntime == "" || containerRuntime == runtime.Auto {
+		node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s to detect container runtime: %s", nodeName, err)
+		}
+		containerRuntime, err = podruntime.DetectFromNode(node)
+		if err != nil {
+			return err
+		}
+	}
+
+	glog.Infof("[%s] Creating privileged pod %s in node %s under namespace %s using the %s runtime socket", requestSpec.reqID, requestSpec.privPodName, nodeName, namespace, containerRuntime)
 	// Specify the privileged pod to be created inside target node
-	pod := privilegedPodSpec(requestSpec.privPodName, nodeName, namespace)
-	err := createPod(client, pod)
+	pod, err := privilegedPodSpec(requestSpec.privPodName, nodeName, namespace, containerRuntime)
 	if err != nil {
 		return err
 	}
+	if err := createPod(client, pod); err != nil {
+		return err
+	}
 
-	// Create a watcher that watches over the pod with same name
-	watch, err := client.CoreV1().Pods(namespace).Watch(metav1.ListOptions{
-		FieldSelector: fields.OneTermEqualSelector("metadata.name", requestSpec.privPodName).String(),
-	})
-	if err != nil {
-		return errors.New("error starting watcher for privilege pod: " + err.Error())
+	// Bound the whole wait-for-running sequence by CmdArgs.PrivPodTimeout, same as before, but
+	// now resume the watch (by resourceVersion) instead of failing outright on a transient
+	// apiserver disconnect, and retry the watch's own establishment on a transient error.
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(CmdArgs.PrivPodTimeout)*time.Second)
+	defer cancel()
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (k8sruntime.Object, error) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", requestSpec.privPodName).String()
+			return client.CoreV1().Pods(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", requestSpec.privPodName).String()
+			return client.CoreV1().Pods(namespace).Watch(options)
+		},
 	}
 
-	// Check that privileged pod is in running phase. If it has not started running after set timeout, raise error
 	glog.Infof("[%s] Waiting for privilege pod %s to be in a running status", requestSpec.reqID, requestSpec.privPodName)
-	err = func() error {
-		for {
-			select {
-			case event := <-watch.ResultChan():
-				p, _ := event.Object.(*v1.Pod)
-				if p.Status.Phase == v1.PodRunning {
-					glog.Infof("[%s] Privileged pod %s is running", requestSpec.reqID, p.Name)
-					return nil
-				}
-			case <-time.After(time.Duration(CmdArgs.PrivPodTimeout) * time.Second):
-				p, _ := client.CoreV1().Pods(namespace).Get(requestSpec.privPodName, metav1.GetOptions{})
-				return fmt.Errorf("privileged pod %s is not running after %d seconds, it is currently in %s phase", p.Name, CmdArgs.PrivPodTimeout, p.Status.Phase)
+	var lastPod *v1.Pod
+	var failure *privPodFailure
+	watchErr := retry.OnError(retry.DefaultBackoff, isRetriableWatchError, func() error {
+		_, err := watchtools.UntilWithSync(waitCtx, lw, &v1.Pod{}, nil, func(event watch.Event) (bool, error) {
+			p, ok := event.Object.(*v1.Pod)
+			if !ok {
+				return false, nil
 			}
-		}
-	}()
-	watch.Stop()
-
-	if err != nil {
+			lastPod = p
+			if p.Status.Phase == v1.PodRunning {
+				glog.Infof("[%s] Privileged pod %s is running", requestSpec.reqID, p.Name)
+				return true, nil
+			}
+			if reason, message := classifyPodFailure(p); reason != "" {
+				failure = &privPodFailure{reason: reason, message: fmt.Sprintf("privileged pod %s: %s", p.Name, message)}
+				return false, failure
+			}
+			return false, nil
+		})
 		return err
+	})
+
+	if failure != nil {
+		return failure
+	}
+	if watchErr != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("aborted waiting for privileged pod %s: %s", requestSpec.privPodName, ctx.Err())
+		}
+		phase := v1.PodUnknown
+		if lastPod != nil {
+			phase = lastPod.Status.Phase
+		}
+		return fmt.Errorf("privileged pod %s is not running after %d seconds, it is currently in %s phase", requestSpec.privPodName, CmdArgs.PrivPodTimeout, phase)
 	}
 
 	return nil
@@ -70,12 +180,15 @@ func createPod(client kubernetes.Interface, pod *v1.Pod) error {
 	return nil
 }
 
-// deletePod deletes the privileged kubernetes pod specified by podName
+// deletePod deletes the privileged kubernetes pod specified by podName, retrying on a transient
+// 5xx so a momentary apiserver blip does not leak the pod
 func deletePod(client kubernetes.Interface, namespace string, requestSpec *requestSpec) error {
 	glog.Infof("[%s] Deleting pod %s under namespace %s", requestSpec.reqID, requestSpec.privPodName, namespace)
 	propagationPolicy := metav1.DeletePropagationForeground
-	err := client.CoreV1().Pods(namespace).Delete(requestSpec.privPodName, &metav1.DeleteOptions{
-		PropagationPolicy: &propagationPolicy,
+	err := retry.OnError(retry.DefaultBackoff, isRetriableDeleteError, func() error {
+		return client.CoreV1().Pods(namespace).Delete(requestSpec.privPodName, &metav1.DeleteOptions{
+			PropagationPolicy: &propagationPolicy,
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("failed to delete pod %s: %s", requestSpec.privPodName, err)
@@ -83,8 +196,10 @@ func deletePod(client kubernetes.Interface, namespace string, requestSpec *reque
 	return nil
 }
 
-// privilegedPodSpecification is the specification for the privileged pod to be created on target node
-func privilegedPodSpec(podName, nodeName, namespace string) *v1.Pod {
+// privilegedPodSpecification is the specification for the privileged pod to be created on target
+// node, mounting the socket of the given containerRuntime (one of pkg/runtime's Docker,
+// Containerd, or CRIO) so the pod can drive it via nsenter regardless of which CRI the node runs
+func privilegedPodSpec(podName, nodeName, namespace string, containerRuntime string) (*v1.Pod, error) {
 	// TypeMeta specification for privileged pod
 	typeMetadata := metav1.TypeMeta{
 		Kind:       "Pod",
@@ -97,13 +212,13 @@ func privilegedPodSpec(podName, nodeName, namespace string) *v1.Pod {
 		Namespace: namespace,
 	}
 
+	volume, volumeMount, err := podruntime.VolumeFor(containerRuntime)
+	if err != nil {
+		return nil, err
+	}
+
 	// Pod spec specifications for privileged pod
 	// with volumeMount, container,
-	volumeMounts := []v1.VolumeMount{{
-		Name:      "docker-sock",
-		MountPath: "/var/run/docker.sock",
-	}}
-
 	privileged := true
 	privilegedContainer := v1.Container{
 		Name:            constants.PrivilegeContainer,
@@ -113,15 +228,7 @@ func privilegedPodSpec(podName, nodeName, namespace string) *v1.Pod {
 		SecurityContext: &v1.SecurityContext{
 			Privileged: &privileged,
 		},
-		VolumeMounts: volumeMounts,
-	}
-
-	hostPathType := v1.HostPathFile
-	volumeSources := v1.VolumeSource{
-		HostPath: &v1.HostPathVolumeSource{
-			Path: "/var/run/docker.sock",
-			Type: &hostPathType,
-		},
+		VolumeMounts: []v1.VolumeMount{volumeMount},
 	}
 
 	podSpecs := v1.PodSpec{
@@ -130,10 +237,7 @@ func privilegedPodSpec(podName, nodeName, namespace string) *v1.Pod {
 		NodeName:           nodeName,
 		RestartPolicy:      v1.RestartPolicyNever,
 		Containers:         []v1.Container{privilegedContainer},
-		Volumes: []v1.Volume{{
-			Name:         "docker-sock",
-			VolumeSource: volumeSources,
-		}},
+		Volumes:            []v1.Volume{volume},
 	}
 
 	pod := v1.Pod{
@@ -141,5 +245,5 @@ func privilegedPodSpec(podName, nodeName, namespace string) *v1.Pod {
 		ObjectMeta: objectMetadata,
 		Spec:       podSpecs,
 	}
-	return &pod
+	return &pod, nil
 }
@@ -3,19 +3,28 @@
 package privexecutor
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
 
 	guuid "github.com/google/uuid"
 
+	"github.com/yahoo/privilege-cmd-controller/pkg/runtime"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
 )
 
-// createNode creates node on fake clientset
-func createNode(client kubernetes.Interface, nodeName string) error {
+// createNode creates a node on the fake clientset, reporting containerRuntimeVersion (e.g.
+// "containerd://1.4.3") the way kubelet does, so createPrivilegedPod's --container-runtime=auto
+// detection has something to inspect
+func createNode(client kubernetes.Interface, nodeName string, containerRuntimeVersion string) error {
 	_, err := client.CoreV1().Nodes().Create(&v1.Node{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "v1",
@@ -24,6 +33,11 @@ func createNode(client kubernetes.Interface, nodeName string) error {
 		ObjectMeta: metav1.ObjectMeta{
 			Name: nodeName,
 		},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{
+				ContainerRuntimeVersion: containerRuntimeVersion,
+			},
+		},
 	})
 	return err
 }
@@ -49,48 +63,179 @@ func isPrivileged(pod *v1.Pod) bool {
 	return false
 }
 
-// TestCreatePrivilegedPod checks if a privileged pod is created upon calling createPrivilegedPod
-// with the correct pod name and on the right target node
+// TestCreatePrivilegedPod checks that createPrivilegedPod creates a privileged pod with the
+// correct name and target node, mounting the socket of the container runtime selected by
+// CmdArgs.ContainerRuntime (or, for "auto", detected from the node), for each supported backend
 func TestCreatePrivilegedPod(t *testing.T) {
+	cases := []struct {
+		name                  string
+		containerRuntime      string
+		nodeRuntimeVersion    string
+		containerID           string
+		expectedSocket        string
+		expectedRuntimePrefix string
+	}{
+		{
+			name:                  "docker",
+			containerRuntime:      runtime.Docker,
+			nodeRuntimeVersion:    "docker://19.3.0",
+			containerID:           "docker://abc123",
+			expectedSocket:        "/var/run/docker.sock",
+			expectedRuntimePrefix: runtime.Docker,
+		},
+		{
+			name:                  "containerd",
+			containerRuntime:      runtime.Containerd,
+			nodeRuntimeVersion:    "containerd://1.4.3",
+			containerID:           "containerd://abc123",
+			expectedSocket:        "/run/containerd/containerd.sock",
+			expectedRuntimePrefix: runtime.Containerd,
+		},
+		{
+			name:                  "crio",
+			containerRuntime:      runtime.CRIO,
+			nodeRuntimeVersion:    "cri-o://1.20.0",
+			containerID:           "cri-o://abc123",
+			expectedSocket:        "/var/run/crio/crio.sock",
+			expectedRuntimePrefix: runtime.CRIO,
+		},
+		{
+			name:                  "auto detects containerd from the node",
+			containerRuntime:      runtime.Auto,
+			nodeRuntimeVersion:    "containerd://1.4.3",
+			containerID:           "containerd://abc123",
+			expectedSocket:        "/run/containerd/containerd.sock",
+			expectedRuntimePrefix: runtime.Containerd,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			namespace := "default"
+			nodeName := "targetNode"
+
+			CmdArgs.ContainerRuntime = c.containerRuntime
+
+			currRequest := requestSpec{
+				privPodName: "priv-test-pod-target-container",
+				reqID:       guuid.New().String(),
+			}
+
+			if err := createNode(client, nodeName, c.nodeRuntimeVersion); err != nil {
+				t.Fatalf("Failed to create node %s on fake client: %s", nodeName, err)
+			}
+
+			// The error here should be that pod is not running; FakeClient will not allow watching over the status of pod
+			err := createPrivilegedPod(client, namespace, nodeName, &currRequest)
+			expectedError := fmt.Errorf("privileged pod %s is not running after %d seconds, it is currently in %s phase", currRequest.privPodName, CmdArgs.PrivPodTimeout, "")
+			if err == nil || err.Error() != expectedError.Error() {
+				t.Errorf("Expected error: %s ; Actual error: %v", expectedError, err)
+			}
+
+			// Test that the pod created is privileged and on the target node
+			pod, err := client.CoreV1().Pods(namespace).Get(currRequest.privPodName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Error in retrieving pod: %s", err)
+			}
+
+			if pod.Name != currRequest.privPodName {
+				t.Errorf("Expected pod name: %s ; Actual pod name: %s", currRequest.privPodName, pod.Name)
+			}
+
+			if pod.Spec.NodeName != nodeName {
+				t.Errorf("Expected node: %s ; Actual node: %s", nodeName, pod.Spec.NodeName)
+			}
+
+			if !isPrivileged(pod) {
+				t.Errorf("Found pod is not privileged")
+			}
+
+			if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].HostPath == nil || pod.Spec.Volumes[0].HostPath.Path != c.expectedSocket {
+				t.Errorf("Expected privilege pod to mount socket %s; got volumes %+v", c.expectedSocket, pod.Spec.Volumes)
+			}
+
+			// Confirm the ContainerID scheme exercised downstream of this backend selection
+			// (getPID's runtime.ForContainerID) parses back to the same runtime
+			cr, _, err := runtime.ForContainerID(c.containerID, func(command []string) (string, error) { return "", nil })
+			if err != nil {
+				t.Fatalf("Failed to parse container ID %s: %s", c.containerID, err)
+			}
+			if cr.Prefix() != c.expectedRuntimePrefix {
+				t.Errorf("Expected container ID %s to resolve to runtime %s; got %s", c.containerID, c.expectedRuntimePrefix, cr.Prefix())
+			}
+		})
+	}
+}
+
+// TestCreatePrivilegedPodRecoversFromTransientWatchError checks that createPrivilegedPod retries
+// establishing its watch on the privilege pod when the apiserver returns a transient error
+// (Unauthorized, ServerTimeout) instead of failing the whole request outright
+func TestCreatePrivilegedPodRecoversFromTransientWatchError(t *testing.T) {
 	client := fake.NewSimpleClientset()
 	namespace := "default"
 	nodeName := "targetNode"
 
+	if err := createNode(client, nodeName, "docker://19.3.0"); err != nil {
+		t.Fatalf("Failed to create node %s on fake client: %s", nodeName, err)
+	}
+
 	currRequest := requestSpec{
 		privPodName: "priv-test-pod-target-container",
 		reqID:       guuid.New().String(),
 	}
 
-	// Create node on client
-	err := createNode(client, nodeName)
-	if err != nil {
-		t.Errorf("Failed to create node %s on fake client: %s", nodeName, err)
+	var watchAttempts int32
+	client.PrependWatchReactor("pods", func(action ktesting.Action) (bool, watch.Interface, error) {
+		switch atomic.AddInt32(&watchAttempts, 1) {
+		case 1:
+			return true, nil, k8serrors.NewUnauthorized("fake transient auth failure")
+		case 2:
+			return true, nil, k8serrors.NewServerTimeout(schema.GroupResource{Resource: "pods"}, "watch", 0)
+		default:
+			return false, nil, nil
+		}
+	})
+
+	err := createPrivilegedPod(client, namespace, nodeName, &currRequest)
+	expectedError := fmt.Errorf("privileged pod %s is not running after %d seconds, it is currently in %s phase", currRequest.privPodName, CmdArgs.PrivPodTimeout, "")
+	if err == nil || err.Error() != expectedError.Error() {
+		t.Errorf("Expected error: %s ; Actual error: %v", expectedError, err)
 	}
 
-	// Create privileged pod on node
-	err = createPrivilegedPod(client, namespace, nodeName, &currRequest)
-	// The error here should be that pod is not running FakeClient will not allow watching over the status of pod
-	expectedError := fmt.Errorf("privileged pod %s is not running after %d seconds, it is currently in %s phase", currRequest.privPodName, 3, "")
-	if err.Error() != expectedError.Error() {
-		t.Errorf("Expected error: %s ; Actual error: %s", expectedError, err)
+	if attempts := atomic.LoadInt32(&watchAttempts); attempts < 3 {
+		t.Errorf("Expected createPrivilegedPod to retry past the transient watch errors; only saw %d watch attempts", attempts)
 	}
+}
 
-	// Test that the pod created is privileged
-	pod, err := client.CoreV1().Pods(namespace).Get(currRequest.privPodName, metav1.GetOptions{})
-	if err != nil {
-		t.Errorf("Error in retrieving pod: %s", err)
+// TestCreatePrivilegedPodAbortsWhenContextDone checks that createPrivilegedPod refuses to create
+// a privileged pod once requestSpec.ctx is done, which is how a replica that has just lost
+// leadership is kept from creating a pod it can no longer supervise
+func TestCreatePrivilegedPodAbortsWhenContextDone(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	namespace := "default"
+	nodeName := "targetNode"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	currRequest := requestSpec{
+		privPodName: "priv-test-pod-target-container",
+		reqID:       guuid.New().String(),
+		ctx:         ctx,
 	}
 
-	if pod.Name != currRequest.privPodName {
-		t.Errorf("Expected pod name: %s ; Actual pod name: %s", pod.Name, currRequest.privPodName)
+	if err := createNode(client, nodeName, "docker://19.3.0"); err != nil {
+		t.Errorf("Failed to create node %s on fake client: %s", nodeName, err)
 	}
 
-	if pod.Spec.NodeName != nodeName {
-		t.Errorf("Expected node: %s ; Actual node: %s", nodeName, pod.Spec.NodeName)
+	err := createPrivilegedPod(client, namespace, nodeName, &currRequest)
+	if err == nil {
+		t.Fatal("expected an error since requestSpec.ctx was already done")
 	}
 
-	if !isPrivileged(pod) {
-		t.Errorf("Found pod is not privileged")
+	if _, getErr := client.CoreV1().Pods(namespace).Get(currRequest.privPodName, metav1.GetOptions{}); !k8serrors.IsNotFound(getErr) {
+		t.Error("privileged pod should not have been created once requestSpec.ctx was done")
 	}
 }
 
@@ -105,13 +250,16 @@ func TestCreatePod(t *testing.T) {
 	}
 
 	// Create node on client
-	err := createNode(client, nodeName)
+	err := createNode(client, nodeName, "docker://19.3.0")
 	if err != nil {
 		t.Errorf("Failed to create node %s on fake client: %s", nodeName, err)
 	}
 
 	// Create pod on the node using privileged pod specs
-	pod := privilegedPodSpec(currRequest.privPodName, nodeName, namespace)
+	pod, err := privilegedPodSpec(currRequest.privPodName, nodeName, namespace, runtime.Docker)
+	if err != nil {
+		t.Errorf("Failed to build privileged pod spec: %s", err)
+	}
 	err = createPod(client, pod)
 	if err != nil {
 		t.Errorf("Failed to create pod %s on fake client: %s", currRequest.privPodName, err)
@@ -3,14 +3,21 @@
 package privexecutor
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/golang/glog"
+	"github.com/yahoo/privilege-cmd-controller/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
+// defaultStreamMaxBytes bounds how much of a streamed command's output is retained for
+// status reporting and the stream server, independent of however much the command itself produces
+const defaultStreamMaxBytes = 1 << 20 // 1 MiB
+
 type remoteCmdExecutor struct {
 	client             kubernetes.Interface
 	restConfig         *rest.Config
@@ -19,6 +26,9 @@ type remoteCmdExecutor struct {
 	container          string
 	containerID        string
 	privilegeContainer string
+	// privPodName is the pod actually exec'd into: the on-demand privilege pod, or a
+	// reused agent pod when CmdArgs.AgentMode is daemonset
+	privPodName string
 }
 
 // executeNsenterCommand executes the specified command from user through using nsenter using privilege pod
@@ -33,26 +43,60 @@ func executeNsenterCommand(rce *remoteCmdExecutor, command []string) (string, er
 	// nsenter with mount, uts, net and pid namespaces
 	// example: nsenter --target 28400 --mount --ipc --uts --net --pid gcore 1
 	commandToExecute := append([]string{"nsenter", "--target", pid, "--ipc", "--uts", "--net", "--pid"}, command...)
-	glog.Infof("[%s] Command to execute on pod %s under namespace %s: %v", rce.requestSpec.reqID, rce.requestSpec.privPodName, rce.namespace, commandToExecute)
+	glog.Infof("[%s] Command to execute on pod %s under namespace %s: %v", rce.requestSpec.reqID, rce.privPodName, rce.namespace, commandToExecute)
+
+	ctx := rce.requestSpec.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-	return execCommandOnPod(rce, commandToExecute)
+	// stdout/stderr are capped at defaultStreamMaxBytes themselves, not just the ring buffer
+	// the stream server tails, so a long-running command like tcpdump or strace can't grow
+	// Status.Output (and the shimmed CRD's Status.Output) without bound.
+	stdout := NewRingBuffer(defaultStreamMaxBytes)
+	stderr := NewRingBuffer(defaultStreamMaxBytes)
+	err = StreamCommandOnPod(ctx, rce, commandToExecute, StreamOptions{
+		Stdout:   stdout,
+		Stderr:   stderr,
+		MaxBytes: defaultStreamMaxBytes,
+	})
+	// StreamWithContext's two internal copy goroutines have already completed by the time it
+	// returns, so appending stderr after stdout here is race-free.
+	output := string(stdout.Bytes()) + string(stderr.Bytes())
+	return output, err
 }
 
-// getPID retrieves the PID of the target container
+// getPID retrieves the PID of the target container, dispatching to the ContainerRuntime
+// implementation matching either the --container-runtime flag or the ContainerID's scheme prefix
 func getPID(rce *remoteCmdExecutor) (string, error) {
-	// Construct command for retrieving PID
-	// Example command for retrieving PID: docker inspect --format '{{ .State.Pid }}' 99ba788b9c7c99a86c3fc2dd400e2d9cb5312d8e5b4f4fb9500b18e1a406226f
 	glog.Infof("[%s] Retrieving PID for target container %s with container ID %s", rce.requestSpec.reqID, rce.container, rce.containerID)
-	command := []string{"docker", "inspect", "--format", "'{{ .State.Pid }}'", rce.containerID}
-	glog.Infof("[%s] Command for retrieving PID for container %s with container ID %s: %v", rce.requestSpec.reqID, rce.container, rce.containerID, command)
 
-	pid, err := execCommandOnPod(rce, command)
+	exec := func(command []string) (string, error) {
+		return execCommandOnPod(rce, command)
+	}
+
+	var cr runtime.ContainerRuntime
+	var id string
+	var err error
+	if CmdArgs.ContainerRuntime == "" || CmdArgs.ContainerRuntime == runtime.Auto {
+		cr, id, err = runtime.ForContainerID(rce.containerID, exec)
+		if err != nil {
+			return "", fmt.Errorf("unable to determine container runtime for container %s with container ID %s: %s", rce.container, rce.containerID, err)
+		}
+	} else {
+		cr, err = runtime.New(CmdArgs.ContainerRuntime, exec)
+		if err != nil {
+			return "", err
+		}
+		parts := strings.SplitN(rce.containerID, "://", 2)
+		id = parts[len(parts)-1]
+	}
+
+	pid, err := cr.PIDFor(id)
 	if err != nil {
 		return "", fmt.Errorf("unable to retrieve PID for container %s with container ID %s: %s", rce.container, rce.containerID, err)
 	}
 
-	// Fix issue with prefix after retrieving value from script
-	pid = pid[1 : len(pid)-2]
 	glog.Infof("[%s] Retrieved successfully PID for container %s: %s", rce.requestSpec.reqID, rce.container, pid)
-	return pid, err
+	return pid, nil
 }
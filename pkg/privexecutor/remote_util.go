@@ -4,6 +4,7 @@ package privexecutor
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 
@@ -14,14 +15,16 @@ import (
 	"k8s.io/client-go/tools/remotecommand"
 )
 
-// execCommandOnPod executes a given command on the target pod
+// execCommandOnPod executes a given command on the target pod via StreamWithContext, so
+// rce.requestSpec.ctx going done both interrupts the call and tears down the underlying SPDY
+// connection instead of abandoning a goroutine that outlives the caller
 func execCommandOnPod(rce *remoteCmdExecutor, command []string) (string, error) {
 	restclient := rce.client.CoreV1().RESTClient()
 
 	req := restclient.Post().
 		Namespace(rce.namespace).
 		Resource("pods").
-		Name(rce.requestSpec.privPodName).
+		Name(rce.privPodName).
 		SubResource("exec")
 
 	req.VersionedParams(&v1.PodExecOptions{
@@ -37,8 +40,13 @@ func execCommandOnPod(rce *remoteCmdExecutor, command []string) (string, error)
 		return "", fmt.Errorf("command %v failed to set SPDY executor: %s", command, err)
 	}
 
+	ctx := rce.requestSpec.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	var stdout, stderr bytes.Buffer
-	err = executor.Stream(remotecommand.StreamOptions{
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
 		Stdout: &stdout,
 		Stderr: &stderr,
 	})
@@ -48,7 +56,9 @@ func execCommandOnPod(rce *remoteCmdExecutor, command []string) (string, error)
 		glog.Errorf("[%s] Command %v returned std err: %v", rce.requestSpec.reqID, command, stderr.String())
 	}
 
-	// Handle errors from executing the remote command
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("command %v cancelled: %s", command, ctx.Err())
+	}
 	if err != nil {
 		return "", fmt.Errorf("command %v failed: %s", command, err)
 	}
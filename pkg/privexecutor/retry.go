@@ -0,0 +1,122 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package privexecutor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// retryRequest is a single pod annotation update enqueued for (re)processing
+type retryRequest struct {
+	oldPod *v1.Pod
+	newPod *v1.Pod
+	reqID  string
+}
+
+// retryQueue re-processes failed Process calls with per-item exponential backoff (the delays
+// carry no jitter: each is exactly base * 2^failures, capped), so a
+// transient API error creating the privileged pod or executing nsenter no longer leaves a
+// request stuck in StatusError until a human re-triggers it.
+type retryQueue struct {
+	queue      workqueue.RateLimitingInterface
+	items      map[string]retryRequest
+	maxRetries int
+}
+
+// newRetryQueue returns a retryQueue that gives up on an item after maxRetries retries (so
+// maxRetries+1 attempts in all), backing off exponentially from a 1 second base up to
+// retryMaxElapsed between attempts
+func newRetryQueue(maxRetries int, retryMaxElapsed time.Duration) *retryQueue {
+	limiter := workqueue.NewItemExponentialFailureRateLimiter(time.Second, retryMaxElapsed)
+	return &retryQueue{
+		queue:      workqueue.NewNamedRateLimitingQueue(limiter, "privilege-cmd-controller"),
+		items:      map[string]retryRequest{},
+		maxRetries: maxRetries,
+	}
+}
+
+// enqueue schedules req for processing, keyed by its reqID
+func (q *retryQueue) enqueue(req retryRequest) {
+	q.items[req.reqID] = req
+	q.queue.Add(req.reqID)
+}
+
+// run drains the queue, calling Process for each item until stop is closed
+func (q *retryQueue) run(pc *privilegeCmdController, stop <-chan struct{}) {
+	go func() {
+		<-stop
+		q.queue.ShutDown()
+	}()
+
+	for q.processNext(pc) {
+	}
+}
+
+// processNext pops and processes a single item, re-enqueueing it with backoff on failure.
+// It returns false once the queue has been shut down.
+func (q *retryQueue) processNext(pc *privilegeCmdController) bool {
+	key, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer q.queue.Done(key)
+
+	reqID := key.(string)
+	req, ok := q.items[reqID]
+	if !ok {
+		q.queue.Forget(key)
+		return true
+	}
+
+	parent := pc.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, time.Duration(CmdArgs.PrivPodTimeout)*time.Second)
+	defer cancel()
+
+	currRequestSpec := &requestSpec{
+		privPodName: privPodNameFor(req.newPod.Name, req.newPod.Annotations[constants.AnnotationExecuteContainer]),
+		reqID:       reqID,
+		requester:   req.newPod.Annotations[constants.AnnotationExecuteRequester],
+		ctx:         ctx,
+	}
+
+	err := Process(pc, req.oldPod, req.newPod, currRequestSpec)
+	if err == nil {
+		delete(q.items, reqID)
+		q.queue.Forget(key)
+		return true
+	}
+
+	var denied *policyDeniedError
+	if q.queue.NumRequeues(key) >= q.maxRetries || errors.As(err, &denied) {
+		if denied != nil {
+			glog.Warningf("[%s] Failing update on pod %s without retry: %s", reqID, req.newPod.Name, err)
+		} else {
+			glog.Errorf("[%s] Exhausted %d retries processing update on pod %s: %s", reqID, q.maxRetries, req.newPod.Name, err)
+		}
+		delete(q.items, reqID)
+
+		if delErr := deletePod(pc.client, CmdArgs.Namespace, currRequestSpec); delErr != nil {
+			glog.Errorf("[%s] Failure to delete pod after error: %s", reqID, delErr)
+		}
+		if updErr := updatePrivilegedCommandExecutorAnnotation(pc.client, req.newPod.Namespace, req.newPod, constants.StatusError, currRequestSpec); updErr != nil {
+			glog.Errorf("[%s] Failure to update annotation after error: %s", reqID, updErr)
+		}
+
+		q.queue.Forget(key)
+		return true
+	}
+
+	glog.Warningf("[%s] Retrying update on pod %s after error (attempt %d/%d): %s", reqID, req.newPod.Name, q.queue.NumRequeues(key)+1, q.maxRetries, err)
+	q.queue.AddRateLimited(key)
+	return true
+}
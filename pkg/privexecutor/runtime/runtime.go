@@ -0,0 +1,69 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+
+// Package runtime produces the Volume/VolumeMount privilegedPodSpec needs to reach the node's
+// container runtime socket, so the privilege pod is not hardcoded to Docker's docker.sock and
+// can instead be pointed at containerd or CRI-O.
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yahoo/privilege-cmd-controller/pkg/runtime"
+	v1 "k8s.io/api/core/v1"
+)
+
+// volumeName is shared by the Volume and VolumeMount referencing the runtime socket
+const volumeName = "container-runtime-sock"
+
+// socketPaths maps a container runtime name (see pkg/runtime's Docker, Containerd, CRIO
+// constants) to the node-local socket privilegedPodSpec mounts into the privilege pod
+var socketPaths = map[string]string{
+	runtime.Docker:     "/var/run/docker.sock",
+	runtime.Containerd: "/run/containerd/containerd.sock",
+	runtime.CRIO:       "/var/run/crio/crio.sock",
+}
+
+// VolumeFor returns the Volume and VolumeMount that make name's container runtime socket
+// available inside the privilege pod, mounted at its node-native path
+func VolumeFor(name string) (v1.Volume, v1.VolumeMount, error) {
+	path, ok := socketPaths[name]
+	if !ok {
+		return v1.Volume{}, v1.VolumeMount{}, fmt.Errorf("unknown container runtime %q", name)
+	}
+
+	hostPathType := v1.HostPathFile
+	volume := v1.Volume{
+		Name: volumeName,
+		VolumeSource: v1.VolumeSource{
+			HostPath: &v1.HostPathVolumeSource{
+				Path: path,
+				Type: &hostPathType,
+			},
+		},
+	}
+	mount := v1.VolumeMount{
+		Name:      volumeName,
+		MountPath: path,
+	}
+	return volume, mount, nil
+}
+
+// DetectFromNode infers the container runtime running on node from its
+// Status.NodeInfo.ContainerRuntimeVersion, e.g. "containerd://1.4.3" resolves to Containerd
+func DetectFromNode(node *v1.Node) (string, error) {
+	version := node.Status.NodeInfo.ContainerRuntimeVersion
+	parts := strings.SplitN(version, "://", 2)
+
+	switch parts[0] {
+	case runtime.Docker:
+		return runtime.Docker, nil
+	case runtime.Containerd:
+		return runtime.Containerd, nil
+	case "cri-o":
+		return runtime.CRIO, nil
+	default:
+		return "", fmt.Errorf("unable to determine container runtime for node %s from runtime version %q", node.Name, version)
+	}
+}
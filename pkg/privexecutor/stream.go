@@ -0,0 +1,159 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package privexecutor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// StreamOptions configures StreamCommandOnPod. Unlike execCommandOnPod's bytes.Buffer
+// accumulation, output is written incrementally to Stdout/Stderr as it arrives, so a
+// long-running command like tcpdump or strace never blocks the controller on a single
+// giant read.
+type StreamOptions struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	TTY    bool
+	// MaxBytes caps how much of stdout/stderr is retained in the request's ring buffer, used
+	// to serve live-tail reads and as a fallback for status reporting. It does not bound what
+	// is written to Stdout/Stderr themselves: callers that persist that output (e.g. into a
+	// status field) must bound Stdout/Stderr the same way, such as by passing a RingBuffer.
+	MaxBytes int64
+	Deadline time.Time
+}
+
+// ringBuffers tracks the live output ring buffer for each in-flight request, keyed by reqID,
+// so the stream server can serve output for a request that is still executing
+var ringBuffers sync.Map // map[string]*streamEntry
+
+// streamEntry pairs a request's live ring buffer with the requester identity it was started
+// under, so the stream server can refuse to serve it to anyone else
+type streamEntry struct {
+	buf       *RingBuffer
+	requester string
+}
+
+// RingBuffer is a bounded, concurrency-safe buffer that keeps only the most recent MaxBytes of
+// writes, surfaced in the request status for operators who did not attach a live stream
+type RingBuffer struct {
+	mu       sync.Mutex
+	data     []byte
+	maxBytes int64
+}
+
+// NewRingBuffer returns a RingBuffer retaining at most maxBytes of the most recent writes
+func NewRingBuffer(maxBytes int64) *RingBuffer {
+	return &RingBuffer{maxBytes: maxBytes}
+}
+
+// Write appends p to the buffer, dropping the oldest bytes once maxBytes is exceeded
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.data = append(r.data, p...)
+	if r.maxBytes > 0 && int64(len(r.data)) > r.maxBytes {
+		r.data = r.data[int64(len(r.data))-r.maxBytes:]
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the buffer's current contents
+func (r *RingBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]byte, len(r.data))
+	copy(out, r.data)
+	return out
+}
+
+// StreamCommandOnPod executes command on the target pod, teeing stdout/stderr into both
+// opts.Stdout/opts.Stderr and a ring buffer registered under rce.requestSpec.reqID so the
+// stream server and the request status can surface output for commands that are still running.
+// It runs the stream via StreamWithContext so that ctx cancellation (e.g. opts.Deadline) both
+// interrupts the call and tears down the underlying SPDY connection, rather than abandoning a
+// goroutine that keeps writing into buffers the caller has already moved on from.
+func StreamCommandOnPod(ctx context.Context, rce *remoteCmdExecutor, command []string, opts StreamOptions) error {
+	restclient := rce.client.CoreV1().RESTClient()
+
+	req := restclient.Post().
+		Namespace(rce.namespace).
+		Resource("pods").
+		Name(rce.privPodName).
+		SubResource("exec")
+
+	req.VersionedParams(&v1.PodExecOptions{
+		Container: rce.privilegeContainer,
+		Command:   command,
+		Stdin:     opts.Stdin != nil,
+		Stdout:    true,
+		Stderr:    true,
+		TTY:       opts.TTY,
+	}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(rce.restConfig, http.MethodPost, req.URL())
+	if err != nil {
+		return fmt.Errorf("command %v failed to set SPDY executor: %s", command, err)
+	}
+
+	if !opts.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, opts.Deadline)
+		defer cancel()
+	}
+
+	buf := NewRingBuffer(opts.MaxBytes)
+	ringBuffers.Store(rce.requestSpec.reqID, &streamEntry{buf: buf, requester: rce.requestSpec.requester})
+	defer ringBuffers.Delete(rce.requestSpec.reqID)
+
+	stdout := io.MultiWriter(opts.Stdout, buf)
+	stderr := io.MultiWriter(opts.Stderr, buf)
+
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  opts.Stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		Tty:    opts.TTY,
+	})
+	if ctx.Err() != nil {
+		glog.Warningf("[%s] Stream deadline exceeded for command %v", rce.requestSpec.reqID, command)
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("command %v failed: %s", command, err)
+	}
+	return nil
+}
+
+// BufferedOutput returns the ring-buffered output recorded so far for reqID, if the request is
+// still streaming; the entry is removed as soon as StreamCommandOnPod returns
+func BufferedOutput(reqID string) ([]byte, bool) {
+	v, ok := ringBuffers.Load(reqID)
+	if !ok {
+		return nil, false
+	}
+	return v.(*streamEntry).buf.Bytes(), true
+}
+
+// RequesterFor returns the requester identity reqID was started under, if the request is still
+// streaming. An empty, true result means the request exists but
+// could not be attributed to a requester (e.g. it was CRD-driven).
+func RequesterFor(reqID string) (string, bool) {
+	v, ok := ringBuffers.Load(reqID)
+	if !ok {
+		return "", false
+	}
+	return v.(*streamEntry).requester, true
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package privexecutor
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+)
+
+// pollInterval is how often the stream server checks the ring buffer for new output
+const pollInterval = 250 * time.Millisecond
+
+// NewStreamHandler returns an http.Handler that proxies the live output of an in-flight
+// privileged command to kubectl-exec-like clients, keyed by reqID as "/stream/<reqID>".
+// It tails the request's ring buffer (populated by StreamCommandOnPod) over chunked HTTP
+// until the command completes or the client disconnects. A client must set
+// constants.StreamRequesterHeader to the same requester identity the request was made under
+// (see pkg/policy's requester check); requests that could not be attributed to a requester are
+// refused rather than served to anyone who learns the reqID.
+func NewStreamHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream/", serveStream)
+	return mux
+}
+
+func serveStream(w http.ResponseWriter, r *http.Request) {
+	reqID := strings.TrimPrefix(r.URL.Path, "/stream/")
+	if reqID == "" {
+		http.Error(w, "reqID is required", http.StatusBadRequest)
+		return
+	}
+
+	requester, ok := RequesterFor(reqID)
+	if !ok {
+		http.Error(w, "no such request", http.StatusNotFound)
+		return
+	}
+	// An empty recorded requester means this request could not be attributed to one (e.g. it is
+	// CRD-driven, which does not yet carry a requester identity): deny by default rather than
+	// serve output to whoever guesses the reqID.
+	if requester == "" || r.Header.Get(constants.StreamRequesterHeader) != requester {
+		glog.Warningf("[%s] Rejected stream request: %s header did not match", reqID, constants.StreamRequesterHeader)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.WriteHeader(http.StatusOK)
+
+	var written int
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		output, streaming := BufferedOutput(reqID)
+		if len(output) > written {
+			if _, err := w.Write(output[written:]); err != nil {
+				glog.Warningf("[%s] Stream client disconnected: %s", reqID, err)
+				return
+			}
+			written = len(output)
+			flusher.Flush()
+		}
+		if !streaming {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListenAndServeStream starts the stream server on addr; it blocks until the server stops
+func ListenAndServeStream(addr string) error {
+	glog.Infof("Serving live privileged command output on %s", addr)
+	if err := http.ListenAndServe(addr, NewStreamHandler()); err != nil {
+		return fmt.Errorf("stream server exited: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package privexecutor
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	guuid "github.com/google/uuid"
+
+	"github.com/yahoo/privilege-cmd-controller/pkg/constants"
+)
+
+// registerStreamEntry stores buf under reqID as StreamCommandOnPod would, for a requester
+// identity, and returns a cleanup func removing it
+func registerStreamEntry(t *testing.T, reqID, requester string) func() {
+	t.Helper()
+	ringBuffers.Store(reqID, &streamEntry{buf: NewRingBuffer(0), requester: requester})
+	return func() { ringBuffers.Delete(reqID) }
+}
+
+// TestServeStream_RequiresMatchingRequester tests that a client must present the reqID's
+// recorded requester identity via constants.StreamRequesterHeader to read its output
+func TestServeStream_RequiresMatchingRequester(t *testing.T) {
+	reqID := guuid.New().String()
+	defer registerStreamEntry(t, reqID, "alice")()
+
+	cases := []struct {
+		name       string
+		header     string
+		wantStatus int
+	}{
+		{name: "no header", header: "", wantStatus: 403},
+		{name: "wrong requester", header: "mallory", wantStatus: 403},
+		{name: "matching requester", header: "alice", wantStatus: 200},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// Cancelled up front: once past the auth check, serveStream's poll loop returns on
+			// its first iteration instead of blocking on ticks that never arrive.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req := httptest.NewRequest("GET", "/stream/"+reqID, nil).WithContext(ctx)
+			if c.header != "" {
+				req.Header.Set(constants.StreamRequesterHeader, c.header)
+			}
+			w := httptest.NewRecorder()
+			serveStream(w, req)
+			if w.Code != c.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, c.wantStatus)
+			}
+		})
+	}
+}
+
+// TestServeStream_UnattributedRequestDenied tests that a request recorded with no requester
+// (e.g. CRD-driven) is refused rather than served to anyone who knows the reqID
+func TestServeStream_UnattributedRequestDenied(t *testing.T) {
+	reqID := guuid.New().String()
+	defer registerStreamEntry(t, reqID, "")()
+
+	req := httptest.NewRequest("GET", "/stream/"+reqID, nil)
+	req.Header.Set(constants.StreamRequesterHeader, "")
+	w := httptest.NewRecorder()
+	serveStream(w, req)
+	if w.Code != 403 {
+		t.Errorf("status = %d, want 403 for an unattributed request", w.Code)
+	}
+}
+
+// TestServeStream_UnknownReqIDNotFound tests that a reqID with no registered stream entry
+// returns 404 rather than falling through to the (not-yet-relevant) auth check
+func TestServeStream_UnknownReqIDNotFound(t *testing.T) {
+	req := httptest.NewRequest("GET", "/stream/"+guuid.New().String(), nil)
+	w := httptest.NewRecorder()
+	serveStream(w, req)
+	if w.Code != 404 {
+		t.Errorf("status = %d, want 404 for an unknown reqID", w.Code)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+
+// Package runtime resolves the PID of a container across different container
+// runtimes so that the nsenter-based privileged command path is not hardcoded
+// to Docker's `docker inspect` and `docker://` ID prefix.
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Docker identifies the Docker container runtime
+	Docker = "docker"
+	// Containerd identifies the containerd container runtime
+	Containerd = "containerd"
+	// CRIO identifies the CRI-O container runtime
+	CRIO = "crio"
+	// Auto selects the runtime implementation based on the ContainerID scheme prefix
+	Auto = "auto"
+
+	// crioScheme is the ContainerID scheme reported by CRI-O, which differs from its flag name
+	crioScheme = "cri-o"
+)
+
+// ContainerRuntime resolves the host PID of a container given its container ID
+type ContainerRuntime interface {
+	// PIDFor returns the host PID of the process backing containerID
+	PIDFor(containerID string) (string, error)
+	// Prefix returns this runtime's name, one of Docker, Containerd, or CRIO. It equals the
+	// ContainerID scheme for Docker and containerd; CRI-O's scheme is "cri-o", its name "crio".
+	Prefix() string
+}
+
+// Execer runs command inside the privilege pod and returns its stdout, matching execCommandOnPod's signature
+type Execer func(command []string) (string, error)
+
+// ForContainerID parses the scheme prefix off containerID (e.g. "docker://<id>") and returns
+// the ContainerRuntime implementation that understands it
+func ForContainerID(containerID string, exec Execer) (ContainerRuntime, string, error) {
+	parts := strings.SplitN(containerID, "://", 2)
+	if len(parts) != 2 {
+		return nil, "", fmt.Errorf("container ID %q does not contain a runtime scheme prefix", containerID)
+	}
+	scheme, id := parts[0], parts[1]
+
+	switch scheme {
+	case Docker:
+		return NewDocker(exec), id, nil
+	case Containerd:
+		return NewContainerd(exec), id, nil
+	case crioScheme:
+		return NewCRIO(exec), id, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported container runtime scheme %q", scheme)
+	}
+}
+
+// New returns the ContainerRuntime implementation named by name, one of Docker, Containerd, or CRIO
+func New(name string, exec Execer) (ContainerRuntime, error) {
+	switch name {
+	case Docker:
+		return NewDocker(exec), nil
+	case Containerd:
+		return NewContainerd(exec), nil
+	case CRIO:
+		return NewCRIO(exec), nil
+	default:
+		return nil, fmt.Errorf("unknown container runtime %q", name)
+	}
+}
+
+// dockerRuntime resolves PIDs via `docker inspect`, matching the controller's pre-existing behavior
+type dockerRuntime struct {
+	exec Execer
+}
+
+// NewDocker returns a ContainerRuntime backed by `docker inspect`
+func NewDocker(exec Execer) ContainerRuntime {
+	return &dockerRuntime{exec: exec}
+}
+
+func (d *dockerRuntime) Prefix() string {
+	return Docker
+}
+
+func (d *dockerRuntime) PIDFor(containerID string) (string, error) {
+	command := []string{"docker", "inspect", "--format", "'{{ .State.Pid }}'", containerID}
+	pid, err := d.exec(command)
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve PID via docker inspect for container %s: %s", containerID, err)
+	}
+	// Fix issue with prefix after retrieving value from script
+	return pid[1 : len(pid)-2], nil
+}
+
+// crictlRuntime resolves PIDs via `crictl inspect`, shared by containerd and CRI-O
+type crictlRuntime struct {
+	exec   Execer
+	prefix string
+}
+
+// NewContainerd returns a ContainerRuntime backed by `crictl inspect`, for the containerd runtime
+func NewContainerd(exec Execer) ContainerRuntime {
+	return &crictlRuntime{exec: exec, prefix: Containerd}
+}
+
+// NewCRIO returns a ContainerRuntime backed by `crictl inspect`, for the CRI-O runtime
+func NewCRIO(exec Execer) ContainerRuntime {
+	return &crictlRuntime{exec: exec, prefix: CRIO}
+}
+
+func (c *crictlRuntime) Prefix() string {
+	return c.prefix
+}
+
+func (c *crictlRuntime) PIDFor(containerID string) (string, error) {
+	command := []string{"crictl", "inspect", "-o", "go-template", "--template", "{{.info.pid}}", containerID}
+	pid, err := c.exec(command)
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve PID via crictl inspect for container %s: %s", containerID, err)
+	}
+	return strings.TrimSpace(pid), nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Oath, Inc.
+// Licensed under the terms of the Apache Version 2.0 License. See LICENSE file for terms.
+package runtime
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestForContainerID checks that each supported ContainerID scheme dispatches to the
+// ContainerRuntime implementation that knows how to resolve its PID
+func TestForContainerID(t *testing.T) {
+	tests := []struct {
+		containerID    string
+		expectedPrefix string
+		expectedCmd    []string
+		// execOutput is what the inspect command prints: docker's --format keeps the literal
+		// quotes around its template, crictl's go-template does not
+		execOutput string
+	}{
+		{
+			containerID:    "docker://abc123",
+			expectedPrefix: Docker,
+			expectedCmd:    []string{"docker", "inspect", "--format", "'{{ .State.Pid }}'", "abc123"},
+			execOutput:     "'1234'\n",
+		},
+		{
+			containerID:    "containerd://abc123",
+			expectedPrefix: Containerd,
+			expectedCmd:    []string{"crictl", "inspect", "-o", "go-template", "--template", "{{.info.pid}}", "abc123"},
+			execOutput:     "1234\n",
+		},
+		{
+			containerID:    "cri-o://abc123",
+			expectedPrefix: CRIO,
+			expectedCmd:    []string{"crictl", "inspect", "-o", "go-template", "--template", "{{.info.pid}}", "abc123"},
+			execOutput:     "1234\n",
+		},
+	}
+
+	for _, test := range tests {
+		var gotCmd []string
+		execOutput := test.execOutput
+		fakeExec := func(command []string) (string, error) {
+			gotCmd = command
+			return execOutput, nil
+		}
+
+		cr, id, err := ForContainerID(test.containerID, fakeExec)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", test.containerID, err)
+		}
+		if cr.Prefix() != test.expectedPrefix {
+			t.Errorf("expected prefix %s; got %s", test.expectedPrefix, cr.Prefix())
+		}
+
+		pid, err := cr.PIDFor(id)
+		if err != nil {
+			t.Fatalf("unexpected error retrieving PID for %s: %s", test.containerID, err)
+		}
+		if pid != "1234" {
+			t.Errorf("expected PID 1234; got %s", pid)
+		}
+		if len(gotCmd) != len(test.expectedCmd) {
+			t.Fatalf("expected command %v; got %v", test.expectedCmd, gotCmd)
+		}
+		for i := range gotCmd {
+			if gotCmd[i] != test.expectedCmd[i] {
+				t.Errorf("expected command %v; got %v", test.expectedCmd, gotCmd)
+			}
+		}
+	}
+}
+
+// TestForContainerIDUnsupportedScheme checks that an unrecognized scheme returns an error
+func TestForContainerIDUnsupportedScheme(t *testing.T) {
+	_, _, err := ForContainerID("rkt://abc123", func(command []string) (string, error) { return "", nil })
+	if err == nil {
+		t.Error("expected an error for unsupported runtime scheme")
+	}
+}
+
+// TestContainerdPIDForError checks that an exec failure is wrapped with context
+func TestContainerdPIDForError(t *testing.T) {
+	cr := NewContainerd(func(command []string) (string, error) {
+		return "", errors.New("exec failed")
+	})
+	_, err := cr.PIDFor("abc123")
+	if err == nil {
+		t.Error("expected an error when exec fails")
+	}
+}